@@ -0,0 +1,141 @@
+package client
+
+import (
+    "bytes"
+    "encoding/csv"
+    "fmt"
+    "strconv"
+    "strings"
+    
+    "admira-etl/internal/models"
+)
+
+// csvRows reads a CSV body with a header row, returning the data rows and
+// a lookup of each row's cell by column name. Cells are trimmed and missing
+// columns read as empty.
+func csvRows(body []byte) ([][]string, func(row []string, column string) string, error) {
+    reader := csv.NewReader(bytes.NewReader(body))
+    reader.FieldsPerRecord = -1
+    
+    rows, err := reader.ReadAll()
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to read CSV: %w", err)
+    }
+    if len(rows) == 0 {
+        return nil, nil, fmt.Errorf("CSV has no header row")
+    }
+    
+    columns := make(map[string]int)
+    for i, name := range rows[0] {
+        columns[strings.ToLower(strings.TrimSpace(name))] = i
+    }
+    
+    cell := func(row []string, column string) string {
+        if i, ok := columns[column]; ok && i < len(row) {
+            return strings.TrimSpace(row[i])
+        }
+        return ""
+    }
+    return rows[1:], cell, nil
+}
+
+// csvOptional maps an empty cell to nil, like a JSON null
+func csvOptional(value string) *string {
+    if value == "" {
+        return nil
+    }
+    return &value
+}
+
+func csvInt(value string) (int, error) {
+    if value == "" {
+        return 0, nil
+    }
+    return strconv.Atoi(value)
+}
+
+func csvFloat(value string) (float64, error) {
+    if value == "" {
+        return 0, nil
+    }
+    return strconv.ParseFloat(value, 64)
+}
+
+// parseAdsCSV reads ads records from a CSV export whose header uses the JSON
+// field names (date, campaign_id, channel, clicks, ...). Blank numeric cells
+// are recorded as missing so a data gap isn't mistaken for an explicit 0.
+func parseAdsCSV(body []byte) ([]models.AdsRecord, error) {
+    rows, cell, err := csvRows(body)
+    if err != nil {
+        return nil, err
+    }
+    
+    records := make([]models.AdsRecord, 0, len(rows))
+    for i, row := range rows {
+        record := models.AdsRecord{
+            Date:        cell(row, "date"),
+            CampaignID:  cell(row, "campaign_id"),
+            Channel:     cell(row, "channel"),
+            UTMCampaign: cell(row, "utm_campaign"),
+            UTMSource:   csvOptional(cell(row, "utm_source")),
+            UTMMedium:   csvOptional(cell(row, "utm_medium")),
+            Missing:     make(map[string]bool),
+        }
+        for _, column := range []string{"clicks", "impressions", "cost"} {
+            if cell(row, column) == "" {
+                record.Missing[column] = true
+            }
+        }
+        if record.Clicks, err = csvInt(cell(row, "clicks")); err != nil {
+            return nil, fmt.Errorf("CSV row %d: invalid clicks: %w", i+2, err)
+        }
+        if record.Impressions, err = csvInt(cell(row, "impressions")); err != nil {
+            return nil, fmt.Errorf("CSV row %d: invalid impressions: %w", i+2, err)
+        }
+        if record.Cost, err = csvFloat(cell(row, "cost")); err != nil {
+            return nil, fmt.Errorf("CSV row %d: invalid cost: %w", i+2, err)
+        }
+        records = append(records, record)
+    }
+    return records, nil
+}
+
+// parseCRMCSV reads CRM opportunities from a CSV export whose header uses
+// the JSON field names (opportunity_id, contact_email, stage, amount, ...).
+// Blank amount and mrr cells are recorded as missing, as in parseAdsCSV.
+func parseCRMCSV(body []byte) ([]models.CRMRecord, error) {
+    rows, cell, err := csvRows(body)
+    if err != nil {
+        return nil, err
+    }
+    
+    records := make([]models.CRMRecord, 0, len(rows))
+    for i, row := range rows {
+        record := models.CRMRecord{
+            OpportunityID: cell(row, "opportunity_id"),
+            ContactEmail:  cell(row, "contact_email"),
+            Phone:         cell(row, "phone"),
+            Stage:         cell(row, "stage"),
+            Currency:      cell(row, "currency"),
+            CreatedAt:     cell(row, "created_at"),
+            CampaignID:    cell(row, "campaign_id"),
+            UTMCampaign:   cell(row, "utm_campaign"),
+            UTMSource:     csvOptional(cell(row, "utm_source")),
+            UTMMedium:     csvOptional(cell(row, "utm_medium")),
+            Missing:       make(map[string]bool),
+        }
+        for _, column := range []string{"amount", "mrr"} {
+            if cell(row, column) == "" {
+                record.Missing[column] = true
+            }
+        }
+        if record.Amount.Value, err = csvFloat(cell(row, "amount")); err != nil {
+            return nil, fmt.Errorf("CSV row %d: invalid amount: %w", i+2, err)
+        }
+        if record.MRR, err = csvFloat(cell(row, "mrr")); err != nil {
+            return nil, fmt.Errorf("CSV row %d: invalid mrr: %w", i+2, err)
+        }
+        records = append(records, record)
+    }
+    return records, nil
+}
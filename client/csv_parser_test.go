@@ -0,0 +1,51 @@
+package client
+
+import "testing"
+
+func TestParseAdsCSVBlankNumbers(t *testing.T) {
+    body := []byte("date,campaign_id,channel,clicks,impressions,cost\n" +
+        "2025-08-01,C-1,google_ads,,   ,0\n")
+    
+    records, err := parseAdsCSV(body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(records) != 1 {
+        t.Fatalf("got %d records, want 1", len(records))
+    }
+    
+    missing := records[0].Missing
+    if !missing["clicks"] {
+        t.Error("empty clicks cell not flagged as missing")
+    }
+    if !missing["impressions"] {
+        t.Error("whitespace impressions cell not flagged as missing")
+    }
+    if missing["cost"] || records[0].Cost != 0 {
+        t.Errorf("explicit 0 cost flagged as missing or misread: %v", records[0].Cost)
+    }
+}
+
+func TestParseCRMCSVBlankNumbers(t *testing.T) {
+    body := []byte("opportunity_id,stage,amount,mrr\n" +
+        "O-1,closed_won,  ,0\n" +
+        "O-2,closed_won,,\n")
+    
+    records, err := parseCRMCSV(body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(records) != 2 {
+        t.Fatalf("got %d records, want 2", len(records))
+    }
+    
+    if !records[0].Missing["amount"] {
+        t.Error("whitespace amount cell not flagged as missing")
+    }
+    if records[0].Missing["mrr"] || records[0].MRR != 0 {
+        t.Errorf("explicit 0 mrr flagged as missing or misread: %v", records[0].MRR)
+    }
+    if !records[1].Missing["amount"] || !records[1].Missing["mrr"] {
+        t.Errorf("empty amount/mrr cells not flagged as missing: %v", records[1].Missing)
+    }
+}
@@ -2,38 +2,91 @@ package client
 
 import (
     "bytes"
+    "compress/gzip"
     "encoding/json"
     "fmt"
     "io"
     "net/http"
+    "regexp"
     "time"
     
     "github.com/sirupsen/logrus"
     "admira-etl/internal/config"
     "admira-etl/internal/models"
+    "admira-etl/internal/stats"
+    "admira-etl/internal/transformer"
 )
 
+// Sink response limits used when the configured ones aren't positive
+const (
+    defaultSinkMaxResponseBytes = 1048576
+    defaultSinkResponseTimeout  = 10 * time.Second
+)
+
+// emailPattern finds email addresses in raw bodies, whatever their format
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
 type HTTPClient struct {
     client        *http.Client
     retryAttempts int
     logger        *logrus.Logger
+    counters      *stats.Counters
+    
+    // Posts to the sink we control may retry harder than upstream fetches
+    exportRetryAttempts int
+    
+    // Body format of each upstream: json or csv
+    adsFormat string
+    crmFormat string
+    
+    sinkMaxResponseBytes int64
+    sinkResponseTimeout  time.Duration
+    
+    // Parse errors are deterministic and fail fast unless this is set for
+    // flaky upstreams that intermittently truncate responses
+    retryParseErrors bool
+    
+    // Debug logging of raw upstream bodies, truncated to bodyLogLimit bytes
+    logUpstreamBodies bool
+    bodyLogLimit      int
+    redactPII         bool // mask emails in logged bodies
 }
 
-func NewHTTPClient(cfg *config.Config, logger *logrus.Logger) *HTTPClient {
+func NewHTTPClient(cfg *config.Config, counters *stats.Counters, logger *logrus.Logger) *HTTPClient {
     return &HTTPClient{
         client: &http.Client{
             Timeout: cfg.HTTPTimeout,
         },
         retryAttempts: cfg.RetryAttempts,
         logger:        logger,
+        counters:      counters,
+        
+        exportRetryAttempts: cfg.ExportRetryAttempts,
+        adsFormat:           cfg.AdsFormat,
+        crmFormat:           cfg.CRMFormat,
+        
+        sinkMaxResponseBytes: cfg.SinkMaxResponseBytes,
+        sinkResponseTimeout:  cfg.SinkResponseTimeout,
+        retryParseErrors:     cfg.RetryParseErrors,
+        logUpstreamBodies:    cfg.LogUpstreamBodies,
+        bodyLogLimit:         cfg.UpstreamBodyLogLimit,
+        redactPII:            cfg.RedactPII,
     }
 }
 
 func (c *HTTPClient) FetchAdsData(url string) (*models.AdsResponse, error) {
     var adsResponse models.AdsResponse
     
-    err := c.retryRequest(url, &adsResponse)
+    err := c.retryRequest(url, func(body []byte) error {
+        if c.adsFormat == "csv" {
+            records, err := parseAdsCSV(body)
+            adsResponse.External.Ads.Performance = records
+            return err
+        }
+        return json.Unmarshal(body, &adsResponse)
+    })
     if err != nil {
+        c.counters.RecordFetchError()
         return nil, fmt.Errorf("failed to fetch ads data: %w", err)
     }
     
@@ -44,8 +97,16 @@ func (c *HTTPClient) FetchAdsData(url string) (*models.AdsResponse, error) {
 func (c *HTTPClient) FetchCRMData(url string) (*models.CRMResponse, error) {
     var crmResponse models.CRMResponse
     
-    err := c.retryRequest(url, &crmResponse)
+    err := c.retryRequest(url, func(body []byte) error {
+        if c.crmFormat == "csv" {
+            records, err := parseCRMCSV(body)
+            crmResponse.External.CRM.Opportunities = records
+            return err
+        }
+        return json.Unmarshal(body, &crmResponse)
+    })
     if err != nil {
+        c.counters.RecordFetchError()
         return nil, fmt.Errorf("failed to fetch CRM data: %w", err)
     }
     
@@ -70,7 +131,26 @@ func (c *HTTPClient) PostExportData(url string, data interface{}, signature stri
     return c.retryPostRequest(req)
 }
 
-func (c *HTTPClient) retryRequest(url string, target interface{}) error {
+// PostAlert sends an alert as JSON to a webhook
+func (c *HTTPClient) PostAlert(url string, alert interface{}) error {
+    jsonData, err := json.Marshal(alert)
+    if err != nil {
+        return fmt.Errorf("failed to marshal alert: %w", err)
+    }
+    
+    req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+    if err != nil {
+        return fmt.Errorf("failed to create alert request: %w", err)
+    }
+    
+    req.Header.Set("Content-Type", "application/json")
+    
+    return c.retryPostRequest(req)
+}
+
+// retryRequest fetches url and hands the body to decode, retrying network
+// errors and 5xx responses with backoff.
+func (c *HTTPClient) retryRequest(url string, decode func(body []byte) error) error {
     var lastErr error
     
     for attempt := 0; attempt < c.retryAttempts; attempt++ {
@@ -109,7 +189,20 @@ func (c *HTTPClient) retryRequest(url string, target interface{}) error {
             continue
         }
         
-        if err := json.Unmarshal(body, target); err != nil {
+        // Backfill files are served as-is, e.g. .json.gz without a
+        // Content-Encoding header, so Go doesn't decompress them
+        if body, err = gunzip(body); err != nil {
+            return err
+        }
+        
+        if c.logUpstreamBodies {
+            c.logBody(url, body)
+        }
+        
+        if err := decode(body); err != nil {
+            if !c.retryParseErrors {
+                return fmt.Errorf("failed to parse response: %w", err)
+            }
             lastErr = err
             continue
         }
@@ -126,13 +219,64 @@ func (c *HTTPClient) retryRequest(url string, target interface{}) error {
     return fmt.Errorf("all retry attempts failed, last error: %w", lastErr)
 }
 
+// gunzip decompresses body when it starts with the gzip magic bytes and
+// returns it unchanged otherwise.
+func gunzip(body []byte) ([]byte, error) {
+    if !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+        return body, nil
+    }
+    
+    reader, err := gzip.NewReader(bytes.NewReader(body))
+    if err != nil {
+        return nil, fmt.Errorf("malformed gzip response: %w", err)
+    }
+    defer reader.Close()
+    
+    decompressed, err := io.ReadAll(reader)
+    if err != nil {
+        return nil, fmt.Errorf("malformed gzip response: %w", err)
+    }
+    return decompressed, nil
+}
+
+// logBody logs a raw upstream body at debug level, truncated to bodyLogLimit.
+// With PII redaction on, emails are masked before truncating so a cut can't
+// leave part of an address unmasked.
+func (c *HTTPClient) logBody(url string, body []byte) {
+    logged := body
+    if c.redactPII {
+        logged = emailPattern.ReplaceAllFunc(logged, func(email []byte) []byte {
+            return []byte(transformer.MaskEmail(string(email)))
+        })
+    }
+    if len(logged) > c.bodyLogLimit {
+        logged = logged[:c.bodyLogLimit]
+    }
+    
+    c.logger.WithFields(logrus.Fields{
+        "url":       url,
+        "bytes":     len(body),
+        "truncated": len(body) > c.bodyLogLimit,
+        "body":      string(logged),
+    }).Debug("Fetched upstream body")
+}
+
 func (c *HTTPClient) retryPostRequest(req *http.Request) error {
     var lastErr error
     
-    for attempt := 0; attempt < c.retryAttempts; attempt++ {
+    for attempt := 0; attempt < c.exportRetryAttempts; attempt++ {
         if attempt > 0 {
             backoffTime := time.Duration(attempt*attempt) * time.Second
             time.Sleep(backoffTime)
+            
+            // The previous attempt consumed the body
+            if req.GetBody != nil {
+                body, err := req.GetBody()
+                if err != nil {
+                    return fmt.Errorf("failed to rewind request body: %w", err)
+                }
+                req.Body = body
+            }
         }
         
         resp, err := c.client.Do(req)
@@ -141,7 +285,7 @@ func (c *HTTPClient) retryPostRequest(req *http.Request) error {
             continue
         }
         
-        resp.Body.Close()
+        c.readSinkResponse(resp)
         
         if resp.StatusCode >= 200 && resp.StatusCode < 300 {
             return nil
@@ -156,3 +300,40 @@ func (c *HTTPClient) retryPostRequest(req *http.Request) error {
     
     return fmt.Errorf("export failed after retries: %w", lastErr)
 }
+
+// readSinkResponse drains at most sinkMaxResponseBytes of the sink's response
+// within sinkResponseTimeout, so a misbehaving sink can't stall or exhaust
+// memory. The body is always closed.
+func (c *HTTPClient) readSinkResponse(resp *http.Response) []byte {
+    maxBytes := c.sinkMaxResponseBytes
+    if maxBytes <= 0 {
+        maxBytes = defaultSinkMaxResponseBytes
+    }
+    timeout := c.sinkResponseTimeout
+    if timeout <= 0 {
+        timeout = defaultSinkResponseTimeout
+    }
+    
+    timer := time.AfterFunc(timeout, func() {
+        resp.Body.Close()
+    })
+    defer timer.Stop()
+    defer resp.Body.Close()
+    
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+    if err != nil {
+        c.logger.WithError(err).Warn("Failed to read sink response")
+        return nil
+    }
+    
+    if int64(len(body)) > maxBytes {
+        c.logger.WithField("max_bytes", maxBytes).Warn("Sink response truncated")
+        body = body[:maxBytes]
+    }
+    
+    c.logger.WithFields(logrus.Fields{
+        "status_code": resp.StatusCode,
+        "bytes":       len(body),
+    }).Debug("Read sink response")
+    return body
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+    "bytes"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+    
+    "github.com/sirupsen/logrus"
+    "admira-etl/internal/config"
+    "admira-etl/internal/stats"
+)
+
+// newTestClient builds a client over cfg with quiet logging and a short
+// HTTP timeout, defaulting the attempt counts the way config.Load does.
+func newTestClient(cfg config.Config) *HTTPClient {
+    logger := logrus.New()
+    logger.SetOutput(io.Discard)
+    
+    cfg.HTTPTimeout = 5 * time.Second
+    if cfg.ExportRetryAttempts <= 0 {
+        cfg.ExportRetryAttempts = cfg.RetryAttempts
+    }
+    return NewHTTPClient(&cfg, stats.NewCounters(), logger)
+}
+
+// countingServer answers every request with status and body, counting hits
+func countingServer(t *testing.T, status int, body string) (*httptest.Server, *int32) {
+    var hits int32
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&hits, 1)
+        w.WriteHeader(status)
+        io.WriteString(w, body)
+    }))
+    t.Cleanup(server.Close)
+    return server, &hits
+}
+
+func TestFetchMalformedJSONRetries(t *testing.T) {
+    tests := []struct {
+        name             string
+        retryParseErrors bool
+        wantHits         int32
+    }{
+        {"parse errors fail fast", false, 1},
+        {"parse errors retried", true, 2},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            server, hits := countingServer(t, http.StatusOK, `{"external": {"ads": `)
+            c := newTestClient(config.Config{RetryAttempts: 2, RetryParseErrors: tt.retryParseErrors})
+            
+            if _, err := c.FetchAdsData(server.URL); err == nil {
+                t.Fatal("expected an error for a malformed body")
+            }
+            if got := atomic.LoadInt32(hits); got != tt.wantHits {
+                t.Errorf("upstream hit %d times, want %d", got, tt.wantHits)
+            }
+        })
+    }
+}
+
+func TestExportUsesExportRetryAttempts(t *testing.T) {
+    server, hits := countingServer(t, http.StatusInternalServerError, "")
+    c := newTestClient(config.Config{RetryAttempts: 1, ExportRetryAttempts: 2})
+    
+    if err := c.PostExportData(server.URL, map[string]string{"k": "v"}, "sig"); err == nil {
+        t.Fatal("expected an error from a failing sink")
+    }
+    if got := atomic.LoadInt32(hits); got != 2 {
+        t.Errorf("sink hit %d times, want EXPORT_RETRY_ATTEMPTS=2", got)
+    }
+}
+
+func TestReadSinkResponseTruncates(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        chunk := bytes.Repeat([]byte("x"), 1024)
+        for i := 0; i < 1024; i++ {
+            if _, err := w.Write(chunk); err != nil {
+                return
+            }
+        }
+    }))
+    t.Cleanup(server.Close)
+    c := newTestClient(config.Config{RetryAttempts: 1, SinkMaxResponseBytes: 16})
+    
+    resp, err := http.Get(server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    body := c.readSinkResponse(resp)
+    if !bytes.Equal(body, bytes.Repeat([]byte("x"), 16)) {
+        t.Errorf("read %d bytes, want SINK_MAX_RESPONSE_BYTES=16", len(body))
+    }
+}
+
+func TestReadSinkResponseNegativeLimitUsesDefault(t *testing.T) {
+    server, _ := countingServer(t, http.StatusOK, "accepted")
+    c := newTestClient(config.Config{RetryAttempts: 1, SinkMaxResponseBytes: -1, SinkResponseTimeout: -time.Second})
+    
+    resp, err := http.Get(server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    if body := c.readSinkResponse(resp); string(body) != "accepted" {
+        t.Errorf("read %q, want the full response under the default limit", body)
+    }
+}
@@ -3,6 +3,7 @@ package config
 import (
     "os"
     "strconv"
+    "strings"
     "time"
     
     "github.com/joho/godotenv"
@@ -10,14 +11,131 @@ import (
 )
 
 type Config struct {
-    AdsAPIURL     string
-    CRMAPIURL     string
-    SinkURL       string
-    SinkSecret    string
-    Port          string
-    LogLevel      string
-    HTTPTimeout   time.Duration
-    RetryAttempts int
+    AdsAPIURL            string
+    CRMAPIURL            string
+    SinkURL              string
+    SinkSecret           string
+    Port                 string
+    HealthPath           string
+    ReadyPath            string
+    LogLevel             string
+    HTTPTimeout          time.Duration
+    RetryAttempts        int
+    RetryParseErrors     bool
+    LogUpstreamBodies    bool
+    UpstreamBodyLogLimit int
+    PrettyJSON           bool
+    SampleSeed           int64
+    
+    // DedupUTMFields lists the UTM fields (campaign, source, medium) added to
+    // the ads dedup key on top of date|campaign_id|channel.
+    DedupUTMFields []string
+    
+    CountLostAsOpportunity bool
+    MatchBy                string // utm or campaign_id
+    
+    // Bounds on reading the export sink's response body
+    SinkMaxResponseBytes int64
+    SinkResponseTimeout  time.Duration
+    
+    // PhoneDefaultRegion is the ISO country code assumed for phone numbers
+    // without an international prefix
+    PhoneDefaultRegion string
+    
+    // AcknowledgedIssues are known-acceptable quality issues, as
+    // "field|description" signatures separated by semicolons
+    AcknowledgedIssues []string
+    
+    RetainDuplicates      bool
+    MaxRetainedDuplicates int
+    
+    // ChannelDefaultSources maps a channel to the UTM source assumed when
+    // an ads record has none (e.g. google_ads -> google)
+    ChannelDefaultSources map[string]string
+    
+    // Export payload JSON keys to keep (allow) or strip (deny)
+    ExportAllowFields []string
+    ExportDenyFields  []string
+    
+    CanonicalizeChannels bool
+    
+    // ExportEmptyAsError makes exporting a date without data fail instead
+    // of succeeding as a no-op
+    ExportEmptyAsError bool
+    
+    // ExportDelayDays is how old a date must be before it can be exported
+    ExportDelayDays int
+    
+    // ExportIncludeQuality adds quality_score, total_records and
+    // valid_records to exported records
+    ExportIncludeQuality bool
+    
+    // AutoExport exports every ingested date to the sink right after ingest
+    AutoExport bool
+    
+    // ChannelMatchWindowDays lets channel metrics match CRM records created
+    // up to this many days after the ads date
+    ChannelMatchWindowDays int
+    
+    // ReadinessRequires is what /readyz waits for: ingest, any or both
+    ReadinessRequires string
+    
+    // ExportRangeConcurrency caps how many dates a multi-date export sends
+    // to the sink at once
+    ExportRangeConcurrency int
+    
+    // RecordIDScheme names records in quality reports: index (ads_<n>) or
+    // business (ads_<date|campaign_id|channel>, crm_<opportunity_id>)
+    RecordIDScheme string
+    
+    // CRMCurrencyPath is where the CRM amount currency lives: "currency"
+    // (flat field) or "amount.currency" (nested); empty skips currency
+    CRMCurrencyPath string
+    
+    // CRMNearDuplicateWindow drops CRM records sharing a contact email with
+    // one created within this window; 0 disables the pass
+    CRMNearDuplicateWindow time.Duration
+    
+    // DailyCaps are per-channel daily cost caps from DAILY_CAP_<channel>
+    // variables; days above a cap raise a budget alert after ingest
+    DailyCaps       map[string]float64
+    AlertWebhookURL string
+    
+    // RedactPII masks contact emails wherever they surface in responses
+    RedactPII bool
+    
+    // ExportRetryAttempts bounds sink and webhook posts; defaults to
+    // RetryAttempts when unset
+    ExportRetryAttempts int
+    
+    // Body format of each upstream, json or csv, so sources can differ
+    AdsFormat string
+    CRMFormat string
+    
+    // MaxCommonIssues caps the quality report's common issues list; the
+    // rest are summarized as "+M more". 0 lists them all
+    MaxCommonIssues int
+    
+    // ValidUTMCampaigns is the canonical campaign list; UTM campaigns not on
+    // it are flagged in quality. Empty accepts any campaign
+    ValidUTMCampaigns []string
+    
+    // ExportAggCampaignLabel is the campaign_id sent for channel-level
+    // aggregates; "none" omits the field
+    ExportAggCampaignLabel string
+    
+    // Overall quality scores below these are reported with severity
+    // critical or warning; anything else is ok
+    QualityCriticalBelow float64
+    QualityWarningBelow  float64
+    
+    // IncrementalMetrics maintains unfiltered channel and funnel metrics as
+    // records are added and evicted instead of computing them per request
+    IncrementalMetrics bool
+    
+    // PromoteMinQualityScore rejects an ingest whose overall quality score
+    // is below it, keeping the current data; 0 promotes every ingest
+    PromoteMinQualityScore float64
 }
 
 func Load() *Config {
@@ -28,16 +146,106 @@ func Load() *Config {
 
     timeout, _ := time.ParseDuration(getEnv("HTTP_TIMEOUT", "30s"))
     retryAttempts, _ := strconv.Atoi(getEnv("RETRY_ATTEMPTS", "3"))
+    retryParseErrors, _ := strconv.ParseBool(getEnv("RETRY_PARSE_ERRORS", "false"))
+    logUpstreamBodies, _ := strconv.ParseBool(getEnv("LOG_UPSTREAM_BODIES", "false"))
+    upstreamBodyLogLimit, _ := strconv.Atoi(getEnv("UPSTREAM_BODY_LOG_LIMIT", "2048"))
+    prettyJSON, _ := strconv.ParseBool(getEnv("PRETTY_JSON", "false"))
+    sampleSeed, _ := strconv.ParseInt(getEnv("SAMPLE_SEED", "42"), 10, 64)
+    countLostAsOpportunity, _ := strconv.ParseBool(getEnv("COUNT_LOST_AS_OPPORTUNITY", "true"))
+    sinkMaxResponseBytes, _ := strconv.ParseInt(getEnv("SINK_MAX_RESPONSE_BYTES", "1048576"), 10, 64)
+    sinkResponseTimeout, _ := time.ParseDuration(getEnv("SINK_RESPONSE_TIMEOUT", "10s"))
+    retainDuplicates, _ := strconv.ParseBool(getEnv("RETAIN_DUPLICATES", "false"))
+    maxRetainedDuplicates, _ := strconv.Atoi(getEnv("MAX_RETAINED_DUPLICATES", "1000"))
+    canonicalizeChannels, _ := strconv.ParseBool(getEnv("CANONICALIZE_CHANNELS", "true"))
+    exportEmptyAsError, _ := strconv.ParseBool(getEnv("EXPORT_EMPTY_AS_ERROR", "false"))
+    exportDelayDays, _ := strconv.Atoi(getEnv("EXPORT_DELAY_DAYS", "0"))
+    exportIncludeQuality, _ := strconv.ParseBool(getEnv("EXPORT_INCLUDE_QUALITY", "false"))
+    autoExport, _ := strconv.ParseBool(getEnv("AUTO_EXPORT", "false"))
+    channelMatchWindowDays, _ := strconv.Atoi(getEnv("CHANNEL_MATCH_WINDOW_DAYS", "0"))
+    exportRangeConcurrency, _ := strconv.Atoi(getEnv("EXPORT_RANGE_CONCURRENCY", "1"))
+    crmNearDuplicateWindow, _ := time.ParseDuration(getEnv("CRM_NEAR_DUPLICATE_WINDOW", "0s"))
+    redactPII, _ := strconv.ParseBool(getEnv("REDACT_PII", "false"))
+    maxCommonIssues, _ := strconv.Atoi(getEnv("MAX_COMMON_ISSUES", "20"))
+    qualityCriticalBelow, _ := strconv.ParseFloat(getEnv("QUALITY_CRITICAL_BELOW", "70"), 64)
+    qualityWarningBelow, _ := strconv.ParseFloat(getEnv("QUALITY_WARNING_BELOW", "90"), 64)
+    incrementalMetrics, _ := strconv.ParseBool(getEnv("INCREMENTAL_METRICS", "false"))
+    promoteMinQualityScore, _ := strconv.ParseFloat(getEnv("PROMOTE_MIN_QUALITY_SCORE", "0"), 64)
+    exportRetryAttempts, _ := strconv.Atoi(getEnv("EXPORT_RETRY_ATTEMPTS", "0"))
+    if exportRetryAttempts <= 0 {
+        exportRetryAttempts = retryAttempts
+    }
+    if maxRetainedDuplicates < 0 {
+        maxRetainedDuplicates = 0
+    }
+    if upstreamBodyLogLimit < 0 {
+        upstreamBodyLogLimit = 0
+    }
+    if sinkMaxResponseBytes <= 0 {
+        sinkMaxResponseBytes = 1048576
+    }
+    if sinkResponseTimeout <= 0 {
+        sinkResponseTimeout = 10 * time.Second
+    }
+    
+    dailyCaps := make(map[string]float64)
+    for channel, value := range getEnvPrefixed("DAILY_CAP_") {
+        if dailyCap, err := strconv.ParseFloat(value, 64); err == nil && dailyCap > 0 {
+            dailyCaps[strings.ToLower(channel)] = dailyCap
+        }
+    }
 
     return &Config{
-        AdsAPIURL:     getEnv("ADS_API_URL", "https://mocki.io/v1/9dcc2981-2bc8-465a-bce3-47767e1278e6"),
-        CRMAPIURL:     getEnv("CRM_API_URL", "https://mocki.io/v1/6a064f10-829d-432c-9f0d-24d5b8cb71c7"),
-        SinkURL:       getEnv("SINK_URL", "https://httpbin.org/post"),
-        SinkSecret:    getEnv("SINK_SECRET", "admira_secret_example"),
-        Port:          getEnv("PORT", "8080"),
-        LogLevel:      getEnv("LOG_LEVEL", "info"),
-        HTTPTimeout:   timeout,
-        RetryAttempts: retryAttempts,
+        AdsAPIURL:              getEnv("ADS_API_URL", "https://mocki.io/v1/9dcc2981-2bc8-465a-bce3-47767e1278e6"),
+        CRMAPIURL:              getEnv("CRM_API_URL", "https://mocki.io/v1/6a064f10-829d-432c-9f0d-24d5b8cb71c7"),
+        SinkURL:                getEnv("SINK_URL", "https://httpbin.org/post"),
+        SinkSecret:             getEnv("SINK_SECRET", "admira_secret_example"),
+        Port:                   getEnv("PORT", "8080"),
+        HealthPath:             getEnv("HEALTH_PATH", "/healthz"),
+        ReadyPath:              getEnv("READY_PATH", "/readyz"),
+        LogLevel:               getEnv("LOG_LEVEL", "info"),
+        HTTPTimeout:            timeout,
+        RetryAttempts:          retryAttempts,
+        RetryParseErrors:       retryParseErrors,
+        LogUpstreamBodies:      logUpstreamBodies,
+        UpstreamBodyLogLimit:   upstreamBodyLogLimit,
+        PrettyJSON:             prettyJSON,
+        SampleSeed:             sampleSeed,
+        DedupUTMFields:         getEnvList("DEDUP_UTM_FIELDS", "", ","),
+        CountLostAsOpportunity: countLostAsOpportunity,
+        MatchBy:                getEnv("MATCH_BY", "utm"),
+        SinkMaxResponseBytes:   sinkMaxResponseBytes,
+        SinkResponseTimeout:    sinkResponseTimeout,
+        PhoneDefaultRegion:     getEnv("PHONE_DEFAULT_REGION", "US"),
+        AcknowledgedIssues:     getEnvList("ACKNOWLEDGED_ISSUES", "", ";"),
+        RetainDuplicates:       retainDuplicates,
+        MaxRetainedDuplicates:  maxRetainedDuplicates,
+        ChannelDefaultSources:  getEnvMap("CHANNEL_DEFAULT_SOURCES", ""),
+        ExportAllowFields:      getEnvList("EXPORT_ALLOW_FIELDS", "", ","),
+        ExportDenyFields:       getEnvList("EXPORT_DENY_FIELDS", "", ","),
+        CanonicalizeChannels:   canonicalizeChannels,
+        ExportEmptyAsError:     exportEmptyAsError,
+        ExportDelayDays:        exportDelayDays,
+        ExportIncludeQuality:   exportIncludeQuality,
+        AutoExport:             autoExport,
+        ChannelMatchWindowDays: channelMatchWindowDays,
+        ReadinessRequires:      getEnv("READINESS_REQUIRES", "ingest"),
+        ExportRangeConcurrency: exportRangeConcurrency,
+        RecordIDScheme:         getEnv("RECORD_ID_SCHEME", "index"),
+        CRMCurrencyPath:        getEnv("CRM_CURRENCY_PATH", ""),
+        CRMNearDuplicateWindow: crmNearDuplicateWindow,
+        DailyCaps:              dailyCaps,
+        AlertWebhookURL:        getEnv("ALERT_WEBHOOK_URL", ""),
+        RedactPII:              redactPII,
+        ExportRetryAttempts:    exportRetryAttempts,
+        AdsFormat:              strings.ToLower(getEnv("ADS_FORMAT", "json")),
+        CRMFormat:              strings.ToLower(getEnv("CRM_FORMAT", "json")),
+        MaxCommonIssues:        maxCommonIssues,
+        ValidUTMCampaigns:      getEnvList("VALID_UTM_CAMPAIGNS", "", ","),
+        ExportAggCampaignLabel: getEnv("EXPORT_AGG_CAMPAIGN_LABEL", "aggregated"),
+        QualityCriticalBelow:   qualityCriticalBelow,
+        QualityWarningBelow:    qualityWarningBelow,
+        IncrementalMetrics:     incrementalMetrics,
+        PromoteMinQualityScore: promoteMinQualityScore,
     }
 }
 
@@ -47,3 +255,38 @@ func getEnv(key, defaultValue string) string {
     }
     return defaultValue
 }
+
+func getEnvList(key, defaultValue, separator string) []string {
+    var values []string
+    for _, value := range strings.Split(getEnv(key, defaultValue), separator) {
+        if value = strings.TrimSpace(value); value != "" {
+            values = append(values, value)
+        }
+    }
+    return values
+}
+
+// getEnvMap parses a comma-separated list of key:value pairs
+func getEnvMap(key, defaultValue string) map[string]string {
+    values := make(map[string]string)
+    for _, pair := range getEnvList(key, defaultValue, ",") {
+        parts := strings.SplitN(pair, ":", 2)
+        if len(parts) == 2 {
+            values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+        }
+    }
+    return values
+}
+
+// getEnvPrefixed returns the non-empty variables starting with prefix, keyed
+// by the rest of their name
+func getEnvPrefixed(prefix string) map[string]string {
+    values := make(map[string]string)
+    for _, env := range os.Environ() {
+        parts := strings.SplitN(env, "=", 2)
+        if len(parts) == 2 && strings.HasPrefix(parts[0], prefix) && strings.TrimSpace(parts[1]) != "" {
+            values[strings.TrimPrefix(parts[0], prefix)] = strings.TrimSpace(parts[1])
+        }
+    }
+    return values
+}
@@ -1,50 +1,80 @@
 package export
 
 import (
+    "bytes"
     "crypto/hmac"
     "crypto/sha256"
     "encoding/hex"
     "encoding/json"
     "fmt"
+    "sync"
     "time"
     
     "github.com/sirupsen/logrus"
     "admira-etl/internal/client"
+    "admira-etl/internal/config"
     "admira-etl/internal/models"
+    "admira-etl/internal/transformer"
 )
 
 type Exporter struct {
     secret     string
     httpClient *client.HTTPClient
     logger     *logrus.Logger
+    
+    // JSON keys permitted in (allow) or stripped from (deny) the payload
+    allowFields []string
+    denyFields  []string
+    
+    emptyAsError   bool
+    includeQuality bool
+    redactPII      bool
+    
+    // campaign_id of channel-level aggregates, empty to omit it
+    aggCampaignLabel string
+    
+    // Content hash of the last export of each record, by date then
+    // channel|campaign_id, so delta exports can skip unchanged records
+    exportedMu sync.Mutex
+    exported   map[string]map[string]string
 }
 
-func NewExporter(secret string, httpClient *client.HTTPClient, logger *logrus.Logger) *Exporter {
+func NewExporter(cfg *config.Config, httpClient *client.HTTPClient, logger *logrus.Logger) *Exporter {
+    aggCampaignLabel := cfg.ExportAggCampaignLabel
+    if aggCampaignLabel == "none" {
+        aggCampaignLabel = ""
+    }
+    
     return &Exporter{
-        secret:     secret,
-        httpClient: httpClient,
-        logger:     logger,
+        secret:      cfg.SinkSecret,
+        httpClient:  httpClient,
+        logger:      logger,
+        allowFields: cfg.ExportAllowFields,
+        denyFields:  cfg.ExportDenyFields,
+        
+        emptyAsError:   cfg.ExportEmptyAsError,
+        includeQuality: cfg.ExportIncludeQuality,
+        redactPII:      cfg.RedactPII,
+        
+        aggCampaignLabel: aggCampaignLabel,
+        exported:         make(map[string]map[string]string),
     }
 }
 
 func (e *Exporter) ExportDailyData(sinkURL string, records []models.ExportRecord) error {
     if len(records) == 0 {
-        return fmt.Errorf("no records to export")
+        if e.emptyAsError {
+            return fmt.Errorf("no records to export")
+        }
+        e.logger.Info("No records to export, skipping")
+        return nil
     }
     
     for _, record := range records {
-        // Create HMAC signature
-        signature, err := e.createSignature(record)
-        if err != nil {
-            e.logger.WithError(err).Error("Failed to create signature")
-            return fmt.Errorf("failed to create signature: %w", err)
-        }
-        
-        // Send to sink
-        if err := e.httpClient.PostExportData(sinkURL, record, signature); err != nil {
-            e.logger.WithError(err).WithField("record", record).Error("Failed to export record")
-            return fmt.Errorf("failed to export record: %w", err)
+        if err := e.exportRecord(sinkURL, record); err != nil {
+            return err
         }
+        e.rememberExport(record)
         
         e.logger.WithFields(logrus.Fields{
             "date":       record.Date,
@@ -56,6 +86,97 @@ func (e *Exporter) ExportDailyData(sinkURL string, records []models.ExportRecord
     return nil
 }
 
+// ChangedRecords drops the records whose content matches their last export,
+// returning the records to send and how many were skipped.
+func (e *Exporter) ChangedRecords(records []models.ExportRecord) ([]models.ExportRecord, int) {
+    e.exportedMu.Lock()
+    defer e.exportedMu.Unlock()
+    
+    changed := []models.ExportRecord{}
+    for _, record := range records {
+        if e.exported[record.Date][exportKey(record)] == contentHash(record) {
+            continue
+        }
+        changed = append(changed, record)
+    }
+    return changed, len(records) - len(changed)
+}
+
+// rememberExport records the content hash of a record sent to the sink
+func (e *Exporter) rememberExport(record models.ExportRecord) {
+    e.exportedMu.Lock()
+    defer e.exportedMu.Unlock()
+    
+    if e.exported[record.Date] == nil {
+        e.exported[record.Date] = make(map[string]string)
+    }
+    e.exported[record.Date][exportKey(record)] = contentHash(record)
+}
+
+// exportKey identifies a record within its date across exports
+func exportKey(record models.ExportRecord) string {
+    return record.Channel + "|" + record.CampaignID
+}
+
+func contentHash(record models.ExportRecord) string {
+    jsonData, _ := json.Marshal(record)
+    sum := sha256.Sum256(jsonData)
+    return hex.EncodeToString(sum[:])
+}
+
+// ExportRawData sends normalized ads and CRM records to the sink one by one,
+// filtered and signed like the daily metrics.
+func (e *Exporter) ExportRawData(sinkURL string, adsRecords []models.RawAdsExportRecord, crmRecords []models.RawCRMExportRecord) error {
+    if len(adsRecords) == 0 && len(crmRecords) == 0 {
+        if e.emptyAsError {
+            return fmt.Errorf("no records to export")
+        }
+        e.logger.Info("No records to export, skipping")
+        return nil
+    }
+    
+    for _, record := range adsRecords {
+        if err := e.exportRecord(sinkURL, record); err != nil {
+            return err
+        }
+    }
+    for _, record := range crmRecords {
+        if err := e.exportRecord(sinkURL, record); err != nil {
+            return err
+        }
+    }
+    
+    e.logger.WithFields(logrus.Fields{
+        "ads_records": len(adsRecords),
+        "crm_records": len(crmRecords),
+    }).Info("Successfully exported raw records")
+    return nil
+}
+
+// exportRecord filters, signs and posts a single record to the sink
+func (e *Exporter) exportRecord(sinkURL string, record interface{}) error {
+    // Drop fields the sink doesn't accept
+    payload, err := e.filterFields(record)
+    if err != nil {
+        e.logger.WithError(err).Error("Failed to filter export fields")
+        return fmt.Errorf("failed to filter export fields: %w", err)
+    }
+    
+    // Create HMAC signature over the payload actually sent
+    signature, err := e.createSignature(payload)
+    if err != nil {
+        e.logger.WithError(err).Error("Failed to create signature")
+        return fmt.Errorf("failed to create signature: %w", err)
+    }
+    
+    // Send to sink
+    if err := e.httpClient.PostExportData(sinkURL, payload, signature); err != nil {
+        e.logger.WithError(err).WithField("record", record).Error("Failed to export record")
+        return fmt.Errorf("failed to export record: %w", err)
+    }
+    return nil
+}
+
 func (e *Exporter) ConvertChannelMetricsToExport(metrics []models.ChannelMetrics) []models.ExportRecord {
     var records []models.ExportRecord
     
@@ -63,7 +184,7 @@ func (e *Exporter) ConvertChannelMetricsToExport(metrics []models.ChannelMetrics
         record := models.ExportRecord{
             Date:          metric.Date,
             Channel:       metric.Channel,
-            CampaignID:    "aggregated", // Since channel metrics are aggregated
+            CampaignID:    e.aggCampaignLabel, // Since channel metrics are aggregated
             Clicks:        metric.Clicks,
             Impressions:   metric.Impressions,
             Cost:          metric.Cost,
@@ -71,11 +192,20 @@ func (e *Exporter) ConvertChannelMetricsToExport(metrics []models.ChannelMetrics
             Opportunities: metric.Opportunities,
             ClosedWon:     metric.ClosedWon,
             Revenue:       metric.Revenue,
+            RecurringRevenue: metric.RecurringRevenue,
             CPC:           metric.CPC,
             CPA:           metric.CPA,
             CVRLeadToOpp:  metric.CVRLeadToOpp,
             CVROppToWon:   metric.CVROppToWon,
             ROAS:          metric.ROAS,
+            AvgDealSize:   metric.AvgDealSize,
+        }
+        
+        if e.includeQuality {
+            qualityScore, totalRecords, validRecords := metric.QualityScore, metric.TotalRecords, metric.ValidRecords
+            record.QualityScore = &qualityScore
+            record.TotalRecords = &totalRecords
+            record.ValidRecords = &validRecords
         }
         records = append(records, record)
     }
@@ -83,6 +213,91 @@ func (e *Exporter) ConvertChannelMetricsToExport(metrics []models.ChannelMetrics
     return records
 }
 
+// ConvertRawToExport maps normalized records to their raw export form.
+// Contact emails are masked when REDACT_PII is set.
+func (e *Exporter) ConvertRawToExport(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord) ([]models.RawAdsExportRecord, []models.RawCRMExportRecord) {
+    rawAds := make([]models.RawAdsExportRecord, 0, len(adsRecords))
+    for _, record := range adsRecords {
+        rawAds = append(rawAds, models.RawAdsExportRecord{
+            RecordType:  "ads",
+            RecordID:    record.Quality.RecordID,
+            Date:        record.Date.Format("2006-01-02"),
+            CampaignID:  record.CampaignID,
+            Channel:     record.Channel,
+            Clicks:      record.Clicks,
+            Impressions: record.Impressions,
+            Cost:        record.Cost,
+            UTMCampaign: record.UTMCampaign,
+            UTMSource:   record.UTMSource,
+            UTMMedium:   record.UTMMedium,
+            IsValid:     record.Quality.IsValid,
+        })
+    }
+    
+    rawCRM := make([]models.RawCRMExportRecord, 0, len(crmRecords))
+    for _, record := range crmRecords {
+        email := record.ContactEmail
+        if e.redactPII {
+            email = transformer.MaskEmail(email)
+        }
+        rawCRM = append(rawCRM, models.RawCRMExportRecord{
+            RecordType:    "crm",
+            RecordID:      record.Quality.RecordID,
+            OpportunityID: record.OpportunityID,
+            ContactEmail:  email,
+            Stage:         record.Stage,
+            Amount:        record.Amount,
+            Currency:      record.Currency,
+            MRR:           record.MRR,
+            CreatedAt:     record.CreatedAt.Format(time.RFC3339),
+            CampaignID:    record.CampaignID,
+            UTMCampaign:   record.UTMCampaign,
+            UTMSource:     record.UTMSource,
+            UTMMedium:     record.UTMMedium,
+            IsValid:       record.Quality.IsValid,
+        })
+    }
+    
+    return rawAds, rawCRM
+}
+
+// filterFields applies the configured allowlist/denylist to the record's
+// JSON keys. The record is returned unchanged when neither is configured.
+func (e *Exporter) filterFields(record interface{}) (interface{}, error) {
+    if len(e.allowFields) == 0 && len(e.denyFields) == 0 {
+        return record, nil
+    }
+    
+    jsonData, err := json.Marshal(record)
+    if err != nil {
+        return nil, err
+    }
+    
+    // UseNumber keeps numeric values exactly as marshalled
+    fields := make(map[string]interface{})
+    decoder := json.NewDecoder(bytes.NewReader(jsonData))
+    decoder.UseNumber()
+    if err := decoder.Decode(&fields); err != nil {
+        return nil, err
+    }
+    
+    if len(e.allowFields) > 0 {
+        allowed := make(map[string]interface{})
+        for _, field := range e.allowFields {
+            if value, ok := fields[field]; ok {
+                allowed[field] = value
+            }
+        }
+        fields = allowed
+    }
+    
+    for _, field := range e.denyFields {
+        delete(fields, field)
+    }
+    
+    return fields, nil
+}
+
 func (e *Exporter) createSignature(data interface{}) (string, error) {
     jsonData, err := json.Marshal(data)
     if err != nil {
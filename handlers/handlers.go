@@ -1,8 +1,13 @@
 package handlers
 
 import (
+    "fmt"
     "net/http"
+    "regexp"
+    "sort"
     "strconv"
+    "strings"
+    "sync"
     "time"
     
     "github.com/gin-gonic/gin"
@@ -15,6 +20,7 @@ import (
     "admira-etl/internal/metrics"
     "admira-etl/internal/export"
     "admira-etl/internal/models"
+    "admira-etl/internal/stats"
 )
 
 type Handler struct {
@@ -24,12 +30,27 @@ type Handler struct {
     store       *storage.MemoryStore
     calculator  *metrics.Calculator
     exporter    *export.Exporter
+    counters    *stats.Counters
     logger      *logrus.Logger
+    
+    // Serializes ingests: a partial ingest carries the other source's stored
+    // records over, so a concurrent one must not replace them in between
+    ingestMu sync.Mutex
+    
+    // Per-date export locks, shared by /export/run and auto-export so the
+    // same date is never sent to the sink twice at once
+    exportLocksMu sync.Mutex
+    exportLocks   map[string]*exportLock
+}
+
+type exportLock struct {
+    sync.Mutex
+    holders int // holding or waiting, the entry is dropped at 0
 }
 
 func New(cfg *config.Config, httpClient *client.HTTPClient, transformer *transformer.Transformer, 
          store *storage.MemoryStore, calculator *metrics.Calculator, exporter *export.Exporter, 
-         logger *logrus.Logger) *Handler {
+         counters *stats.Counters, logger *logrus.Logger) *Handler {
     return &Handler{
         config:      cfg,
         httpClient:  httpClient,
@@ -37,32 +58,144 @@ func New(cfg *config.Config, httpClient *client.HTTPClient, transformer *transfo
         store:       store,
         calculator:  calculator,
         exporter:    exporter,
+        counters:    counters,
         logger:      logger,
+        exportLocks: make(map[string]*exportLock),
+    }
+}
+
+// respond writes a JSON response, indented when the caller passes
+// pretty=true or PRETTY_JSON is enabled, compact otherwise.
+func (h *Handler) respond(c *gin.Context, status int, obj interface{}) {
+    if h.config.PrettyJSON || c.Query("pretty") == "true" {
+        c.IndentedJSON(status, obj)
+        return
+    }
+    c.JSON(status, obj)
+}
+
+// Metrics response versions: v1 is the original shape, frozen in the V1
+// models, v2 is the current one
+const (
+    minAPIVersion     = 1
+    currentAPIVersion = 2
+)
+
+var vendorMediaType = regexp.MustCompile(`application/vnd\.admira\.v(\d+)\+json`)
+
+// apiVersion resolves the response version a client pinned with ?v=N or
+// Accept: application/vnd.admira.vN+json, defaulting to the current one.
+func (h *Handler) apiVersion(c *gin.Context) (int, bool) {
+    requested := c.Query("v")
+    if requested == "" {
+        if match := vendorMediaType.FindStringSubmatch(c.GetHeader("Accept")); match != nil {
+            requested = match[1]
+        }
+    }
+    if requested == "" {
+        return currentAPIVersion, true
+    }
+    
+    version, err := strconv.Atoi(requested)
+    if err != nil || version < minAPIVersion || version > currentAPIVersion {
+        return 0, false
+    }
+    return version, true
+}
+
+// pagination reads the limit (default 10, negative for no limit) and offset
+// query parameters
+func pagination(c *gin.Context) (limit, offset int, err error) {
+    limit, err = strconv.Atoi(c.DefaultQuery("limit", "10"))
+    if err != nil {
+        return 0, 0, fmt.Errorf("limit must be an integer")
     }
+    offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+    if err != nil {
+        return 0, 0, fmt.Errorf("offset must be an integer")
+    }
+    
+    if offset < 0 {
+        offset = 0
+    }
+    if limit < 0 {
+        limit = 0 // no limit
+    }
+    return limit, offset, nil
+}
+
+// pageNumber is the 1-based page holding the first returned record when
+// pages are limit records long: offset=5&limit=10 starts inside page 1, so
+// clients paging by offset should rely on offset and has_more instead.
+func pageNumber(offset, limit int) int {
+    if limit <= 0 || offset <= 0 {
+        return 1
+    }
+    return offset/limit + 1
+}
+
+// pageBounds returns the slice bounds of a page of total records. A limit
+// of 0 means no limit: everything after offset is returned.
+func pageBounds(total, offset, limit int) (start, end int) {
+    start = offset
+    if start > total {
+        start = total
+    }
+    
+    end = total
+    if limit > 0 && start+limit < total {
+        end = start + limit
+    }
+    return start, end
 }
 
 func (h *Handler) HealthCheck(c *gin.Context) {
-    c.JSON(http.StatusOK, gin.H{
+    h.respond(c, http.StatusOK, gin.H{
         "status":    "ok",
         "timestamp": time.Now().Format(time.RFC3339),
         "service":   "admira-etl",
     })
 }
 
+// ReadinessCheck reports, besides the READINESS_REQUIRES status, the state
+// of the stored data so orchestration can tell a startup race
+// (never_ingested) from an ingest that stored no records (empty).
 func (h *Handler) ReadinessCheck(c *gin.Context) {
-    if h.store.HasData() {
-        c.JSON(http.StatusOK, gin.H{
-            "status":        "ready",
-            "has_data":      true,
-            "last_ingest":   h.store.GetLastIngestTime().Format(time.RFC3339),
-        })
-    } else {
-        c.JSON(http.StatusServiceUnavailable, gin.H{
-            "status":   "not ready",
-            "has_data": false,
-            "message":  "No data ingested yet",
+    hasData := h.store.HasData("any")
+    state := "ready"
+    switch {
+    case !h.store.HasIngested():
+        state = "never_ingested"
+    case !hasData:
+        state = "empty"
+    }
+    
+    if h.store.HasData(h.config.ReadinessRequires) {
+        h.respond(c, http.StatusOK, gin.H{
+            "status":      "ready",
+            "state":       state,
+            "has_data":    hasData,
+            "last_ingest": h.store.GetLastIngestTime().Format(time.RFC3339),
         })
+        return
+    }
+    
+    response := gin.H{
+        "status":   "not ready",
+        "state":    state,
+        "has_data": hasData,
+        "requires": h.config.ReadinessRequires,
+        "message":  "No data ingested yet",
     }
+    if state != "never_ingested" {
+        response["last_ingest"] = h.store.GetLastIngestTime().Format(time.RFC3339)
+        response["message"] = "Ingested data does not meet the readiness requirement"
+    }
+    h.respond(c, http.StatusServiceUnavailable, response)
+}
+
+func (h *Handler) GetCounters(c *gin.Context) {
+    h.respond(c, http.StatusOK, h.counters.Snapshot())
 }
 
 func (h *Handler) IngestData(c *gin.Context) {
@@ -75,66 +208,186 @@ func (h *Handler) IngestData(c *gin.Context) {
             sinceTime = t
             h.logger.WithField("since", sinceTime).Info("Filtering data since date")
         } else {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format, use YYYY-MM-DD"})
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid date format, use YYYY-MM-DD"})
             return
         }
     }
     
-    h.logger.Info("Starting data ingestion")
-    
-    // Fetch ads data
-    adsResponse, err := h.httpClient.FetchAdsData(h.config.AdsAPIURL)
-    if err != nil {
-        h.logger.WithError(err).Error("Failed to fetch ads data")
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ads data"})
+    sampleRate, err := strconv.ParseFloat(c.DefaultQuery("sample_rate", "1.0"), 64)
+    if err != nil || sampleRate < 0 || sampleRate > 1 {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid sample_rate, use a value between 0.0 and 1.0"})
         return
     }
     
-    // Fetch CRM data
-    crmResponse, err := h.httpClient.FetchCRMData(h.config.CRMAPIURL)
-    if err != nil {
-        h.logger.WithError(err).Error("Failed to fetch CRM data")
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch CRM data"})
-        return
+    sources := strings.Split(c.DefaultQuery("sources", "ads,crm"), ",")
+    ingestAds := false
+    ingestCRM := false
+    for _, source := range sources {
+        switch strings.TrimSpace(source) {
+        case "ads":
+            ingestAds = true
+        case "crm":
+            ingestCRM = true
+        default:
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid sources, use a comma-separated list of ads and crm"})
+            return
+        }
     }
     
-    // Transform and filter data with quality validation
-    normalizedAds := h.transformer.NormalizeAdsRecords(adsResponse.External.Ads.Performance)
-    normalizedCRM := h.transformer.NormalizeCRMRecords(crmResponse.External.CRM.Opportunities)
+    h.logger.WithField("sources", sources).Info("Starting data ingestion")
+    
+    h.ingestMu.Lock()
+    defer h.ingestMu.Unlock()
+    
+    // Sources not being ingested keep their stored records
+    normalizedAds := h.store.GetAdsRecords()
+    normalizedCRM := h.store.GetCRMRecords()
+    var adsDuplicates []models.NormalizedAdsRecord
+    var crmDuplicates []models.NormalizedCRMRecord
+    ingestedSources := []string{}
     
-    // Apply since filter if specified
-    if !sinceTime.IsZero() {
-        filteredAds := []models.NormalizedAdsRecord{}
-        for _, record := range normalizedAds {
-            if record.Date.Equal(sinceTime) || record.Date.After(sinceTime) {
-                filteredAds = append(filteredAds, record)
+    if ingestAds {
+        // Fetch ads data
+        adsResponse, err := h.httpClient.FetchAdsData(h.config.AdsAPIURL)
+        if err != nil {
+            h.logger.WithError(err).Error("Failed to fetch ads data")
+            h.respond(c, http.StatusInternalServerError, gin.H{"error": "Failed to fetch ads data"})
+            return
+        }
+        
+        // Sample raw records for load tests, then transform with quality validation
+        adsRaw := h.transformer.SampleAdsRecords(adsResponse.External.Ads.Performance, sampleRate, h.config.SampleSeed)
+        normalizedAds, adsDuplicates = h.transformer.NormalizeAdsRecords(adsRaw)
+        
+        // Apply since filter if specified
+        if !sinceTime.IsZero() {
+            filteredAds := []models.NormalizedAdsRecord{}
+            for _, record := range normalizedAds {
+                if record.Date.Equal(sinceTime) || record.Date.After(sinceTime) {
+                    filteredAds = append(filteredAds, record)
+                }
             }
+            normalizedAds = filteredAds
         }
-        normalizedAds = filteredAds
+        ingestedSources = append(ingestedSources, "ads")
+    }
+    
+    if ingestCRM {
+        // Fetch CRM data
+        crmResponse, err := h.httpClient.FetchCRMData(h.config.CRMAPIURL)
+        if err != nil {
+            h.logger.WithError(err).Error("Failed to fetch CRM data")
+            h.respond(c, http.StatusInternalServerError, gin.H{"error": "Failed to fetch CRM data"})
+            return
+        }
+        
+        // Sample raw records for load tests, then transform with quality validation
+        crmRaw := h.transformer.SampleCRMRecords(crmResponse.External.CRM.Opportunities, sampleRate, h.config.SampleSeed)
+        normalizedCRM, crmDuplicates = h.transformer.NormalizeCRMRecords(crmRaw)
         
-        filteredCRM := []models.NormalizedCRMRecord{}
-        for _, record := range normalizedCRM {
-            recordDate := time.Date(record.CreatedAt.Year(), record.CreatedAt.Month(), record.CreatedAt.Day(), 0, 0, 0, 0, time.UTC)
-            if recordDate.Equal(sinceTime) || recordDate.After(sinceTime) {
-                filteredCRM = append(filteredCRM, record)
+        // Apply since filter if specified
+        if !sinceTime.IsZero() {
+            filteredCRM := []models.NormalizedCRMRecord{}
+            for _, record := range normalizedCRM {
+                recordDate := time.Date(record.CreatedAt.Year(), record.CreatedAt.Month(), record.CreatedAt.Day(), 0, 0, 0, 0, time.UTC)
+                if recordDate.Equal(sinceTime) || recordDate.After(sinceTime) {
+                    filteredCRM = append(filteredCRM, record)
+                }
             }
+            normalizedCRM = filteredCRM
         }
-        normalizedCRM = filteredCRM
+        ingestedSources = append(ingestedSources, "crm")
     }
     
-    // Generate quality report
+    crmNearDuplicates := 0
+    for _, record := range crmDuplicates {
+        if _, near := record.Quality.FieldErrors["near_duplicate"]; near {
+            crmNearDuplicates++
+        }
+    }
+    
+    if sampleRate < 1 {
+        h.logger.WithFields(logrus.Fields{
+            "sample_rate": sampleRate,
+            "sources":     ingestedSources,
+        }).Info("Ingested a sample of upstream records")
+    }
+    
+    // Generate quality report over the resulting dataset
     qualityReport := h.transformer.GenerateQualityReport(normalizedAds, normalizedCRM)
     
-    // Store data
-    h.store.StoreAdsRecords(normalizedAds)
-    h.store.StoreCRMRecords(normalizedCRM)
+    // Build the new dataset in staging; a source not ingested keeps its records
+    previousChecksum := h.store.Checksum()
+    staging := h.store.Stage(normalizedAds, normalizedCRM)
+    
+    // A low-quality ingest is discarded and the current data keeps serving.
+    // An empty ingest has no score and is never promoted over the threshold.
+    overallScore := qualityReport.Summary.OverallQualityScore
+    if h.config.PromoteMinQualityScore > 0 && (overallScore == nil || *overallScore < h.config.PromoteMinQualityScore) {
+        h.logger.WithFields(logrus.Fields{
+            "sources":       ingestedSources,
+            "quality_score": overallScore,
+            "min_score":     h.config.PromoteMinQualityScore,
+        }).Warn("Ingest quality below promotion threshold, keeping previous data")
+        
+        h.respond(c, http.StatusUnprocessableEntity, models.IngestResponse{
+            Status:         "rejected",
+            AdsRecords:     len(normalizedAds),
+            CRMRecords:     len(normalizedCRM),
+            ProcessedAt:    time.Now().Format(time.RFC3339),
+            Message:        "Quality score below PROMOTE_MIN_QUALITY_SCORE, previous data kept",
+            SampleRate:     sampleRate,
+            Sources:        ingestedSources,
+            QualitySummary: qualityReport.Summary,
+            Checksum:       previousChecksum,
+            Promoted:       false,
+        })
+        return
+    }
+    
+    // The reports are published in the same swap as the records; a source
+    // not ingested keeps its duplicates and collisions from the previous ingest
+    staging.SetQualityReport(qualityReport)
+    duplicateReport := h.store.GetDuplicateReport()
+    collisions := h.transformer.DuplicateCollisions(adsDuplicates, crmDuplicates)
+    if ingestAds {
+        duplicateReport.Ads = collisions.Ads
+        if h.config.RetainDuplicates {
+            staging.SetAdsDuplicates(adsDuplicates)
+        }
+    }
+    if ingestCRM {
+        duplicateReport.CRM = collisions.CRM
+        if h.config.RetainDuplicates {
+            staging.SetCRMDuplicates(crmDuplicates)
+        }
+    }
+    staging.SetDuplicateReport(duplicateReport)
+    
+    h.store.Promote(staging)
+    checksum := staging.Checksum()
+    
+    // The carried-over source was counted by the ingest that fetched it
+    ingestedRecords := 0
+    if ingestAds {
+        ingestedRecords += len(normalizedAds)
+    }
+    if ingestCRM {
+        ingestedRecords += len(normalizedCRM)
+    }
+    h.counters.RecordIngest(ingestedRecords)
     
     duration := time.Since(startTime)
     h.logger.WithFields(logrus.Fields{
+        "sources":        ingestedSources,
         "ads_records":    len(normalizedAds),
         "crm_records":    len(normalizedCRM),
+        "crm_near_dups":  crmNearDuplicates,
+        "data_changed":   checksum != previousChecksum,
         "duration_ms":    duration.Milliseconds(),
         "quality_score":  qualityReport.Summary.OverallQualityScore,
+        "quality_status": qualityReport.Summary.Status,
+        "severity":       qualityReport.Summary.Severity,
         "valid_ads":      qualityReport.Summary.ValidAdsRecords,
         "valid_crm":      qualityReport.Summary.ValidCRMRecords,
     }).Info("Data ingestion completed with quality validation")
@@ -144,22 +397,190 @@ func (h *Handler) IngestData(c *gin.Context) {
         h.logger.WithField("common_issues", qualityReport.Summary.CommonIssues).Warn("Data quality issues detected")
     }
     
-    c.JSON(http.StatusOK, models.IngestResponse{
-        Status:         "success",
-        AdsRecords:     len(normalizedAds),
-        CRMRecords:     len(normalizedCRM),
-        ProcessedAt:    time.Now().Format(time.RFC3339),
-        Message:        "Data ingested and processed with quality validation",
-        QualitySummary: qualityReport.Summary,
+    var budgetAlerts []models.BudgetAlert
+    if ingestAds {
+        budgetAlerts = h.calculator.CheckDailyCaps(normalizedAds)
+        h.sendBudgetAlerts(budgetAlerts)
+    }
+    
+    // Chain the export of the ingested dates; failures don't undo the ingest
+    var autoExport []models.AutoExportResult
+    if h.config.AutoExport {
+        dates := make(map[string]bool)
+        if ingestAds {
+            for _, record := range normalizedAds {
+                dates[record.Date.Format("2006-01-02")] = true
+            }
+        }
+        if ingestCRM {
+            for _, record := range normalizedCRM {
+                dates[record.CreatedAt.Format("2006-01-02")] = true
+            }
+        }
+        autoExport = h.autoExport(dates)
+    }
+    
+    h.respond(c, http.StatusOK, models.IngestResponse{
+        Status:            "success",
+        AdsRecords:        len(normalizedAds),
+        CRMRecords:        len(normalizedCRM),
+        ProcessedAt:       time.Now().Format(time.RFC3339),
+        Message:           "Data ingested and processed with quality validation",
+        SampleRate:        sampleRate,
+        Sources:           ingestedSources,
+        QualitySummary:    qualityReport.Summary,
+        CRMNearDuplicates: crmNearDuplicates,
+        BudgetAlerts:      budgetAlerts,
+        AutoExport:        autoExport,
+        Checksum:          checksum,
+        DataChanged:       checksum != previousChecksum,
+        Promoted:          true,
+    })
+}
+
+// GetIngestStatus reports the latest ingest and the checksum of the stored
+// data, so callers can tell whether a re-ingest changed anything.
+func (h *Handler) GetIngestStatus(c *gin.Context) {
+    lastIngest := ""
+    if t := h.store.GetLastIngestTime(); !t.IsZero() {
+        lastIngest = t.Format(time.RFC3339)
+    }
+    
+    h.respond(c, http.StatusOK, gin.H{
+        "last_ingest": lastIngest,
+        "ads_records": len(h.store.GetAdsRecords()),
+        "crm_records": len(h.store.GetCRMRecords()),
+        "checksum":    h.store.Checksum(),
     })
 }
 
+// sendBudgetAlerts logs each daily cap breach and posts it to the alert
+// webhook when one is configured. Delivery failures are only logged.
+func (h *Handler) sendBudgetAlerts(alerts []models.BudgetAlert) {
+    for _, alert := range alerts {
+        h.logger.WithFields(logrus.Fields{
+            "date":    alert.Date,
+            "channel": alert.Channel,
+            "cap":     alert.Cap,
+            "cost":    alert.Cost,
+        }).Warn("Daily channel cost exceeded its cap")
+        
+        if h.config.AlertWebhookURL == "" {
+            continue
+        }
+        if err := h.httpClient.PostAlert(h.config.AlertWebhookURL, alert); err != nil {
+            h.logger.WithError(err).WithField("date", alert.Date).Error("Failed to send budget alert")
+        }
+    }
+}
+
+// autoExport exports the stored metrics of each given date to the sink,
+// skipping dates still inside EXPORT_DELAY_DAYS. Up to
+// EXPORT_RANGE_CONCURRENCY dates export at once; results stay in date order.
+func (h *Handler) autoExport(dates map[string]bool) []models.AutoExportResult {
+    sortedDates := make([]string, 0, len(dates))
+    for dateStr := range dates {
+        sortedDates = append(sortedDates, dateStr)
+    }
+    sort.Strings(sortedDates)
+    
+    now := time.Now().UTC()
+    latest := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -h.config.ExportDelayDays)
+    
+    concurrency := h.config.ExportRangeConcurrency
+    if concurrency < 1 {
+        concurrency = 1
+    }
+    
+    results := make([]models.AutoExportResult, len(sortedDates))
+    slots := make(chan struct{}, concurrency)
+    var wg sync.WaitGroup
+    for i, dateStr := range sortedDates {
+        wg.Add(1)
+        slots <- struct{}{}
+        go func(i int, dateStr string) {
+            defer wg.Done()
+            defer func() { <-slots }()
+            results[i] = h.exportDate(dateStr, latest)
+        }(i, dateStr)
+    }
+    wg.Wait()
+    
+    h.logger.WithFields(logrus.Fields{
+        "dates":       len(results),
+        "concurrency": concurrency,
+    }).Info("Auto export after ingest completed")
+    return results
+}
+
+// lockExportDate waits until no other export of dateStr is running and
+// claims it; the returned func releases it.
+func (h *Handler) lockExportDate(dateStr string) func() {
+    h.exportLocksMu.Lock()
+    lock, ok := h.exportLocks[dateStr]
+    if !ok {
+        lock = &exportLock{}
+        h.exportLocks[dateStr] = lock
+    }
+    lock.holders++
+    h.exportLocksMu.Unlock()
+    
+    lock.Lock()
+    return func() {
+        lock.Unlock()
+        h.exportLocksMu.Lock()
+        lock.holders--
+        if lock.holders == 0 {
+            delete(h.exportLocks, dateStr)
+        }
+        h.exportLocksMu.Unlock()
+    }
+}
+
+// exportDate exports one date for autoExport; latest is the newest date
+// outside EXPORT_DELAY_DAYS.
+func (h *Handler) exportDate(dateStr string, latest time.Time) models.AutoExportResult {
+    result := models.AutoExportResult{Date: dateStr}
+    if h.config.SinkURL != "" {
+        defer h.lockExportDate(dateStr)()
+    }
+    date, _ := time.Parse("2006-01-02", dateStr)
+    
+    adsRecords := h.store.GetAdsRecordsByDateRange(date, date)
+    switch {
+    case h.config.SinkURL == "":
+        result.Status = "skipped"
+        result.Error = "no sink configured"
+    case h.config.ExportDelayDays > 0 && date.After(latest):
+        result.Status = "skipped"
+        result.Error = "attribution has not settled"
+    case len(adsRecords) == 0:
+        result.Status = "skipped"
+        result.Error = "no ads data for date"
+    default:
+        crmRecords := h.store.GetCRMRecordsByDateRange(date, date.AddDate(0, 0, h.config.ChannelMatchWindowDays))
+        channelMetrics := h.calculator.CalculateChannelMetricsWithQuality(adsRecords, crmRecords, "")
+        exportRecords := h.exporter.ConvertChannelMetricsToExport(channelMetrics)
+        result.RecordsCount = len(exportRecords)
+        
+        if err := h.exporter.ExportDailyData(h.config.SinkURL, exportRecords); err != nil {
+            h.logger.WithError(err).WithField("date", dateStr).Error("Auto export failed")
+            result.Status = "failed"
+            result.Error = err.Error()
+        } else {
+            h.counters.RecordExport()
+            result.Status = "exported"
+        }
+    }
+    return result
+}
+
 func (h *Handler) GetDataQualityReport(c *gin.Context) {
     adsRecords := h.store.GetAdsRecords()
     crmRecords := h.store.GetCRMRecords()
     
     if len(adsRecords) == 0 && len(crmRecords) == 0 {
-        c.JSON(http.StatusNotFound, gin.H{
+        h.respond(c, http.StatusNotFound, gin.H{
             "error": "No data available for quality analysis. Please run ingestion first.",
         })
         return
@@ -167,27 +588,121 @@ func (h *Handler) GetDataQualityReport(c *gin.Context) {
     
     qualityReport := h.transformer.GenerateQualityReport(adsRecords, crmRecords)
     
-    c.JSON(http.StatusOK, qualityReport)
+    h.respond(c, http.StatusOK, qualityReport)
+}
+
+func (h *Handler) AcknowledgeQualityIssues(c *gin.Context) {
+    var request models.AcknowledgeRequest
+    if err := c.ShouldBindJSON(&request); err != nil {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid request body, expected {\"issues\": [{\"field\": ..., \"description\": ...}]}"})
+        return
+    }
+    
+    acknowledged := h.transformer.AcknowledgeIssues(request.Issues)
+    h.logger.WithField("count", len(request.Issues)).Info("Acknowledged quality issues")
+    
+    h.respond(c, http.StatusOK, gin.H{
+        "status":       "success",
+        "acknowledged": acknowledged,
+    })
+}
+
+func (h *Handler) GetDataQualityDiff(c *gin.Context) {
+    current, previous := h.store.GetQualityReports()
+    if current == nil || previous == nil {
+        h.respond(c, http.StatusNotFound, gin.H{
+            "error": "Quality diff requires at least two ingestions.",
+        })
+        return
+    }
+    
+    h.respond(c, http.StatusOK, h.transformer.DiffQualityReports(*current, *previous))
+}
+
+// GetDuplicateCollisions lists the dedup keys shared by several records in
+// the latest ingest, per source. Unlike /debug/duplicates it doesn't need
+// RETAIN_DUPLICATES.
+func (h *Handler) GetDuplicateCollisions(c *gin.Context) {
+    h.respond(c, http.StatusOK, h.store.GetDuplicateReport())
+}
+
+// maxCompletenessDays caps the /quality/completeness range, which lists
+// every day in it
+const maxCompletenessDays = 366
+
+func (h *Handler) GetDataCompleteness(c *gin.Context) {
+    fromTime, err := time.Parse("2006-01-02", c.Query("from"))
+    if err != nil {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "from parameter is required (YYYY-MM-DD)"})
+        return
+    }
+    
+    toTime, err := time.Parse("2006-01-02", c.Query("to"))
+    if err != nil {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "to parameter is required (YYYY-MM-DD)"})
+        return
+    }
+    
+    if toTime.Before(fromTime) {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "to date must not be before from date"})
+        return
+    }
+    
+    if toTime.Sub(fromTime) >= maxCompletenessDays*24*time.Hour {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "date range must not exceed 366 days", "max_days": maxCompletenessDays})
+        return
+    }
+    
+    adsRecords := h.store.GetAdsRecordsByDateRange(fromTime, toTime)
+    crmRecords := h.store.GetCRMRecordsByDateRange(fromTime, toTime)
+    
+    h.respond(c, http.StatusOK, h.transformer.CheckCompleteness(adsRecords, crmRecords, fromTime, toTime))
+}
+
+func (h *Handler) GetDuplicates(c *gin.Context) {
+    if !h.config.RetainDuplicates {
+        h.respond(c, http.StatusNotFound, gin.H{"error": "Duplicate retention is disabled, set RETAIN_DUPLICATES=true"})
+        return
+    }
+    
+    adsDuplicates, crmDuplicates := h.store.GetDuplicates()
+    if h.config.RedactPII {
+        for i := range crmDuplicates {
+            crmDuplicates[i].ContactEmail = transformer.MaskEmail(crmDuplicates[i].ContactEmail)
+        }
+    }
+    
+    h.respond(c, http.StatusOK, gin.H{
+        "ads_duplicates": adsDuplicates,
+        "crm_duplicates": crmDuplicates,
+        "max_retained":   h.config.MaxRetainedDuplicates,
+    })
 }
 
 func (h *Handler) GetChannelMetrics(c *gin.Context) {
+    version, ok := h.apiVersion(c)
+    if !ok {
+        h.respond(c, http.StatusNotAcceptable, gin.H{"error": "Unsupported API version", "supported_versions": []int{1, 2}})
+        return
+    }
+    
     from := c.Query("from")
     to := c.Query("to")
     channel := c.Query("channel")
-    limitStr := c.DefaultQuery("limit", "10")
-    offsetStr := c.DefaultQuery("offset", "0")
     
-    limit, _ := strconv.Atoi(limitStr)
-    offset, _ := strconv.Atoi(offsetStr)
+    limit, offset, err := pagination(c)
+    if err != nil {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
     
     // Parse dates
     var fromTime, toTime time.Time
-    var err error
     
     if from != "" {
         fromTime, err = time.Parse("2006-01-02", from)
         if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date format, use YYYY-MM-DD"})
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid from date format, use YYYY-MM-DD"})
             return
         }
     }
@@ -195,7 +710,7 @@ func (h *Handler) GetChannelMetrics(c *gin.Context) {
     if to != "" {
         toTime, err = time.Parse("2006-01-02", to)
         if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date format, use YYYY-MM-DD"})
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid to date format, use YYYY-MM-DD"})
             return
         }
     }
@@ -204,60 +719,221 @@ func (h *Handler) GetChannelMetrics(c *gin.Context) {
     var adsRecords []models.NormalizedAdsRecord
     var crmRecords []models.NormalizedCRMRecord
     
+    meta := models.MetricsMeta{Filters: map[string]string{}}
     if !fromTime.IsZero() && !toTime.IsZero() {
         adsRecords = h.store.GetAdsRecordsByDateRange(fromTime, toTime)
-        crmRecords = h.store.GetCRMRecordsByDateRange(fromTime, toTime)
+        crmRecords = h.store.GetCRMRecordsByDateRange(fromTime, toTime.AddDate(0, 0, h.config.ChannelMatchWindowDays))
+        meta.From = fromTime.Format("2006-01-02")
+        meta.To = toTime.Format("2006-01-02")
     } else {
         adsRecords = h.store.GetAdsRecords()
         crmRecords = h.store.GetCRMRecords()
     }
     
-    // Calculate metrics with quality scores
-    metrics := h.calculator.CalculateChannelMetricsWithQuality(adsRecords, crmRecords, channel)
+    // Calculate metrics with quality scores; unfiltered ranges are served
+    // from the aggregates precomputed at ingest when available
+    var metrics []models.ChannelMetrics
+    if aggregates := h.store.GetAggregates(); aggregates != nil && meta.From == "" {
+        metrics = []models.ChannelMetrics{}
+        for _, metric := range aggregates.ChannelMetrics {
+            if channel == "" || metric.Channel == channel {
+                metrics = append(metrics, metric)
+            }
+        }
+    } else {
+        metrics = h.calculator.CalculateChannelMetricsWithQuality(adsRecords, crmRecords, channel)
+    }
+    if channel != "" {
+        meta.Filters["channel"] = channel
+    }
+    if sortBy := c.Query("sort"); sortBy != "" {
+        if err := h.calculator.SortChannelMetrics(metrics, sortBy); err != nil {
+            h.respond(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        meta.Filters["sort"] = sortBy
+    }
     
     // Apply pagination
     total := len(metrics)
-    start := offset
-    end := offset + limit
+    start, end := pageBounds(total, offset, limit)
     
-    if start > total {
-        start = total
+    paginatedMetrics := metrics[start:end]
+    
+    response := models.MetricsResponse{
+        Data:       paginatedMetrics,
+        Total:      total,
+        Page:       pageNumber(offset, limit),
+        Limit:      limit,
+        Offset:     offset,
+        HasMore:    end < total,
+        APIVersion: version,
     }
-    if end > total {
-        end = total
+    
+    // v1 clients get the frozen original shape
+    if version == 1 {
+        data := make([]models.ChannelMetricsV1, 0, len(paginatedMetrics))
+        for _, metric := range paginatedMetrics {
+            data = append(data, metric.V1())
+        }
+        h.respond(c, http.StatusOK, response.V1(data))
+        return
     }
     
-    paginatedMetrics := metrics[start:end]
+    response.Meta = &meta
+    h.respond(c, http.StatusOK, response)
+}
+
+func (h *Handler) GetChannelDistribution(c *gin.Context) {
+    channel := c.Query("channel")
+    if channel == "" {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "channel parameter is required"})
+        return
+    }
+    metric := c.DefaultQuery("metric", "cost")
     
-    response := models.MetricsResponse{
-        Data:    paginatedMetrics,
-        Total:   total,
-        Page:    offset/limit + 1,
-        Limit:   limit,
-        HasMore: end < total,
+    // Parse dates
+    var fromTime, toTime time.Time
+    var err error
+    
+    if from := c.Query("from"); from != "" {
+        fromTime, err = time.Parse("2006-01-02", from)
+        if err != nil {
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid from date format, use YYYY-MM-DD"})
+            return
+        }
     }
     
-    c.JSON(http.StatusOK, response)
+    if to := c.Query("to"); to != "" {
+        toTime, err = time.Parse("2006-01-02", to)
+        if err != nil {
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid to date format, use YYYY-MM-DD"})
+            return
+        }
+    }
+    
+    // Get filtered data
+    var adsRecords []models.NormalizedAdsRecord
+    var crmRecords []models.NormalizedCRMRecord
+    
+    if !fromTime.IsZero() && !toTime.IsZero() {
+        adsRecords = h.store.GetAdsRecordsByDateRange(fromTime, toTime)
+        crmRecords = h.store.GetCRMRecordsByDateRange(fromTime, toTime.AddDate(0, 0, h.config.ChannelMatchWindowDays))
+    } else {
+        adsRecords = h.store.GetAdsRecords()
+        crmRecords = h.store.GetCRMRecords()
+    }
+    
+    distribution, err := h.calculator.CalculateChannelDistribution(adsRecords, crmRecords, channel, metric)
+    if err != nil {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    
+    h.respond(c, http.StatusOK, distribution)
+}
+
+// GetMetricsSummary reports grand totals and the blended CAC for a period
+func (h *Handler) GetMetricsSummary(c *gin.Context) {
+    // Parse dates
+    var fromTime, toTime time.Time
+    var err error
+    
+    if from := c.Query("from"); from != "" {
+        fromTime, err = time.Parse("2006-01-02", from)
+        if err != nil {
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid from date format, use YYYY-MM-DD"})
+            return
+        }
+    }
+    
+    if to := c.Query("to"); to != "" {
+        toTime, err = time.Parse("2006-01-02", to)
+        if err != nil {
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid to date format, use YYYY-MM-DD"})
+            return
+        }
+    }
+    
+    var adsRecords []models.NormalizedAdsRecord
+    var crmRecords []models.NormalizedCRMRecord
+    rangeApplied := !fromTime.IsZero() && !toTime.IsZero()
+    if rangeApplied {
+        adsRecords = h.store.GetAdsRecordsByDateRange(fromTime, toTime)
+        crmRecords = h.store.GetCRMRecordsByDateRange(fromTime, toTime)
+    } else {
+        adsRecords = h.store.GetAdsRecords()
+        crmRecords = h.store.GetCRMRecords()
+    }
+    
+    summary := h.calculator.CalculateSummary(adsRecords, crmRecords)
+    if rangeApplied {
+        summary.From = fromTime.Format("2006-01-02")
+        summary.To = toTime.Format("2006-01-02")
+    }
+    
+    h.respond(c, http.StatusOK, summary)
+}
+
+// GetCRMHourlyMetrics reports CRM conversions and revenue per hour of day
+func (h *Handler) GetCRMHourlyMetrics(c *gin.Context) {
+    // Parse dates
+    var fromTime, toTime time.Time
+    var err error
+    
+    if from := c.Query("from"); from != "" {
+        fromTime, err = time.Parse("2006-01-02", from)
+        if err != nil {
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid from date format, use YYYY-MM-DD"})
+            return
+        }
+    }
+    
+    if to := c.Query("to"); to != "" {
+        toTime, err = time.Parse("2006-01-02", to)
+        if err != nil {
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid to date format, use YYYY-MM-DD"})
+            return
+        }
+    }
+    
+    var crmRecords []models.NormalizedCRMRecord
+    if !fromTime.IsZero() && !toTime.IsZero() {
+        crmRecords = h.store.GetCRMRecordsByDateRange(fromTime, toTime)
+    } else {
+        crmRecords = h.store.GetCRMRecords()
+    }
+    
+    h.respond(c, http.StatusOK, gin.H{
+        "data":  h.calculator.CalculateHourlyCRMMetrics(crmRecords),
+        "total": len(crmRecords),
+    })
 }
 
 func (h *Handler) GetFunnelMetrics(c *gin.Context) {
+    version, ok := h.apiVersion(c)
+    if !ok {
+        h.respond(c, http.StatusNotAcceptable, gin.H{"error": "Unsupported API version", "supported_versions": []int{1, 2}})
+        return
+    }
+    
     from := c.Query("from")
     to := c.Query("to")
     utmCampaign := c.Query("utm_campaign")
-    limitStr := c.DefaultQuery("limit", "10")
-    offsetStr := c.DefaultQuery("offset", "0")
     
-    limit, _ := strconv.Atoi(limitStr)
-    offset, _ := strconv.Atoi(offsetStr)
+    limit, offset, err := pagination(c)
+    if err != nil {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
     
     // Parse dates
     var fromTime, toTime time.Time
-    var err error
     
     if from != "" {
         fromTime, err = time.Parse("2006-01-02", from)
         if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date format, use YYYY-MM-DD"})
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid from date format, use YYYY-MM-DD"})
             return
         }
     }
@@ -265,7 +941,7 @@ func (h *Handler) GetFunnelMetrics(c *gin.Context) {
     if to != "" {
         toTime, err = time.Parse("2006-01-02", to)
         if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date format, use YYYY-MM-DD"})
+            h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid to date format, use YYYY-MM-DD"})
             return
         }
     }
@@ -274,83 +950,213 @@ func (h *Handler) GetFunnelMetrics(c *gin.Context) {
     var adsRecords []models.NormalizedAdsRecord
     var crmRecords []models.NormalizedCRMRecord
     
+    meta := models.MetricsMeta{Filters: map[string]string{}}
     if !fromTime.IsZero() && !toTime.IsZero() {
         adsRecords = h.store.GetAdsRecordsByDateRange(fromTime, toTime)
         crmRecords = h.store.GetCRMRecordsByDateRange(fromTime, toTime)
+        meta.From = fromTime.Format("2006-01-02")
+        meta.To = toTime.Format("2006-01-02")
     } else {
         adsRecords = h.store.GetAdsRecords()
         crmRecords = h.store.GetCRMRecords()
     }
     
     // Calculate metrics with quality scores
-    metrics := h.calculator.CalculateFunnelMetricsWithQuality(adsRecords, crmRecords, utmCampaign)
+    includeChannel := c.Query("funnel_include_channel") == "true"
+    excludeUnknownUTM := c.Query("exclude_unknown_utm") == "true"
+    var metrics []models.FunnelMetrics
+    if aggregates := h.store.GetAggregates(); aggregates != nil && meta.From == "" && !includeChannel && !excludeUnknownUTM {
+        metrics = []models.FunnelMetrics{}
+        for _, metric := range aggregates.FunnelMetrics {
+            if utmCampaign == "" || metric.UTMCampaign == utmCampaign {
+                metrics = append(metrics, metric)
+            }
+        }
+    } else {
+        metrics = h.calculator.CalculateFunnelMetricsWithQuality(adsRecords, crmRecords, utmCampaign, includeChannel, excludeUnknownUTM)
+    }
+    if utmCampaign != "" {
+        meta.Filters["utm_campaign"] = utmCampaign
+    }
+    if includeChannel {
+        meta.Filters["funnel_include_channel"] = "true"
+    }
+    if excludeUnknownUTM {
+        meta.Filters["exclude_unknown_utm"] = "true"
+    }
     
     // Apply pagination
     total := len(metrics)
-    start := offset
-    end := offset + limit
-    
-    if start > total {
-        start = total
-    }
-    if end > total {
-        end = total
-    }
+    start, end := pageBounds(total, offset, limit)
     
     paginatedMetrics := metrics[start:end]
     
     response := models.MetricsResponse{
-        Data:    paginatedMetrics,
-        Total:   total,
-        Page:    offset/limit + 1,
-        Limit:   limit,
-        HasMore: end < total,
+        Data:       paginatedMetrics,
+        Total:      total,
+        Page:       pageNumber(offset, limit),
+        Limit:      limit,
+        Offset:     offset,
+        HasMore:    end < total,
+        APIVersion: version,
     }
     
-    c.JSON(http.StatusOK, response)
+    // v1 clients get the frozen original shape
+    if version == 1 {
+        data := make([]models.FunnelMetricsV1, 0, len(paginatedMetrics))
+        for _, metric := range paginatedMetrics {
+            data = append(data, metric.V1())
+        }
+        h.respond(c, http.StatusOK, response.V1(data))
+        return
+    }
+    
+    response.Meta = &meta
+    h.respond(c, http.StatusOK, response)
 }
 
 func (h *Handler) ExportData(c *gin.Context) {
     dateStr := c.Query("date")
     if dateStr == "" {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "date parameter is required (YYYY-MM-DD)"})
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "date parameter is required (YYYY-MM-DD)"})
         return
     }
     
     date, err := time.Parse("2006-01-02", dateStr)
     if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format, use YYYY-MM-DD"})
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid date format, use YYYY-MM-DD"})
         return
     }
     
-    // Get data for the specific date
-    adsRecords := h.store.GetAdsRecordsByDateRange(date, date)
-    crmRecords := h.store.GetCRMRecordsByDateRange(date, date)
+    level := c.DefaultQuery("level", "metrics")
+    if level != "metrics" && level != "raw" {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "Invalid level, use metrics or raw"})
+        return
+    }
     
-    if len(adsRecords) == 0 {
-        c.JSON(http.StatusNotFound, gin.H{"error": "No data found for the specified date"})
+    delta := c.Query("delta") == "true"
+    if delta && level == "raw" {
+        h.respond(c, http.StatusBadRequest, gin.H{"error": "delta is only supported for level=metrics"})
         return
     }
     
-    // Calculate metrics for export
-    channelMetrics := h.calculator.CalculateChannelMetricsWithQuality(adsRecords, crmRecords, "")
-    exportRecords := h.exporter.ConvertChannelMetricsToExport(channelMetrics)
+    // Attribution keeps accruing after a click, so only export settled dates
+    if h.config.ExportDelayDays > 0 && c.Query("force") != "true" {
+        now := time.Now().UTC()
+        latest := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -h.config.ExportDelayDays)
+        if date.After(latest) {
+            h.respond(c, http.StatusConflict, gin.H{
+                "error":             "Date is too recent to export, attribution has not settled. Use force=true to override.",
+                "export_delay_days": h.config.ExportDelayDays,
+                "latest_exportable": latest.Format("2006-01-02"),
+            })
+            return
+        }
+    }
     
-    // Export to sink if URL is configured
+    // Read the records under the lock so a queued export sees what the one
+    // before it sent
     if h.config.SinkURL != "" {
-        if err := h.exporter.ExportDailyData(h.config.SinkURL, exportRecords); err != nil {
-            h.logger.WithError(err).Error("Failed to export to sink")
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+        defer h.lockExportDate(dateStr)()
+    }
+    
+    // Get data for the specific date; metrics also match lagged CRM records
+    adsRecords := h.store.GetAdsRecordsByDateRange(date, date)
+    crmTo := date.AddDate(0, 0, h.config.ChannelMatchWindowDays)
+    if level == "raw" {
+        crmTo = date
+    }
+    crmRecords := h.store.GetCRMRecordsByDateRange(date, crmTo)
+    
+    if len(adsRecords) == 0 && (level == "metrics" || len(crmRecords) == 0) {
+        if h.config.ExportEmptyAsError {
+            h.respond(c, http.StatusNotFound, gin.H{"error": "No data found for the specified date"})
             return
         }
+        
+        h.respond(c, http.StatusOK, gin.H{
+            "status":        "success",
+            "date":          dateStr,
+            "level":         level,
+            "records_count": 0,
+            "message":       "No data found for the specified date, nothing to export",
+        })
+        return
+    }
+    
+    var data interface{}
+    var recordsCount, skipped int
+    var exportToSink func() error
+    
+    if level == "raw" {
+        rawAds, rawCRM := h.exporter.ConvertRawToExport(adsRecords, crmRecords)
+        data = gin.H{"ads": rawAds, "crm": rawCRM}
+        recordsCount = len(rawAds) + len(rawCRM)
+        exportToSink = func() error {
+            return h.exporter.ExportRawData(h.config.SinkURL, rawAds, rawCRM)
+        }
+    } else {
+        // Calculate metrics for export
+        channelMetrics := h.calculator.CalculateChannelMetricsWithQuality(adsRecords, crmRecords, "")
+        exportRecords := h.exporter.ConvertChannelMetricsToExport(channelMetrics)
+        
+        // Only records that changed since their last export are sent
+        if delta {
+            exportRecords, skipped = h.exporter.ChangedRecords(exportRecords)
+        }
+        data = exportRecords
+        recordsCount = len(exportRecords)
+        exportToSink = func() error {
+            return h.exporter.ExportDailyData(h.config.SinkURL, exportRecords)
+        }
+    }
+    
+    // Without a sink the computed records are only previewed
+    if h.config.SinkURL == "" {
+        h.respond(c, http.StatusOK, gin.H{
+            "status":        "success",
+            "date":          dateStr,
+            "level":         level,
+            "records_count": recordsCount,
+            "skipped_count": skipped,
+            "exported":      false,
+            "reason":        "no sink configured",
+            "data":          data,
+        })
+        return
+    }
+    
+    // Nothing changed since the last export, so there is nothing to send
+    if delta && recordsCount == 0 {
+        h.respond(c, http.StatusOK, gin.H{
+            "status":        "success",
+            "date":          dateStr,
+            "level":         level,
+            "records_count": 0,
+            "skipped_count": skipped,
+            "exported":      false,
+            "reason":        "no changes since last export",
+            "data":          data,
+        })
+        return
+    }
+    
+    if err := exportToSink(); err != nil {
+        h.logger.WithError(err).Error("Failed to export to sink")
+        h.respond(c, http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+        return
     }
+    h.counters.RecordExport()
     
-    c.JSON(http.StatusOK, gin.H{
-        "status":         "success",
-        "date":           dateStr,
-        "records_count":  len(exportRecords),
-        "exported_at":    time.Now().Format(time.RFC3339),
-        "sink_url":       h.config.SinkURL,
-        "data":           exportRecords,
+    h.respond(c, http.StatusOK, gin.H{
+        "status":        "success",
+        "date":          dateStr,
+        "level":         level,
+        "records_count": recordsCount,
+        "skipped_count": skipped,
+        "exported":      true,
+        "exported_at":   time.Now().Format(time.RFC3339),
+        "sink_url":      h.config.SinkURL,
+        "data":          data,
     })
 }
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+    
+    "github.com/gin-gonic/gin"
+    "github.com/sirupsen/logrus"
+    
+    "admira-etl/internal/client"
+    "admira-etl/internal/config"
+    "admira-etl/internal/export"
+    "admira-etl/internal/metrics"
+    "admira-etl/internal/models"
+    "admira-etl/internal/stats"
+    "admira-etl/internal/storage"
+    "admira-etl/internal/transformer"
+)
+
+// newTestHandler serves one day of google_ads data with a matching lead
+func newTestHandler(t *testing.T) *gin.Engine {
+    gin.SetMode(gin.TestMode)
+    logger := logrus.New()
+    logger.SetOutput(io.Discard)
+    
+    date := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+    store := storage.NewMemoryStore(0)
+    store.Promote(store.Stage(
+        []models.NormalizedAdsRecord{{Date: date, CampaignID: "C-1", Channel: "google_ads", Clicks: 10, Cost: 5, UTMCampaign: "summer", UTMKey: "k"}},
+        []models.NormalizedCRMRecord{{OpportunityID: "O-1", Stage: "lead", CreatedAt: date, UTMCampaign: "summer", UTMKey: "k"}},
+    ))
+    
+    cfg := &config.Config{}
+    h := New(cfg, nil, transformer.New(cfg), store, metrics.NewCalculator(cfg), nil, nil, logger)
+    
+    router := gin.New()
+    router.GET("/metrics/channel", h.GetChannelMetrics)
+    router.GET("/metrics/funnel", h.GetFunnelMetrics)
+    router.GET("/quality/completeness", h.GetDataCompleteness)
+    return router
+}
+
+// get serves a GET request and returns the status and body
+func get(router *gin.Engine, url string) (int, []byte) {
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+    return w.Code, w.Body.Bytes()
+}
+
+// keys lists the keys of a JSON object, sorted and comma-joined
+func keys(t *testing.T, data []byte) string {
+    var object map[string]json.RawMessage
+    if err := json.Unmarshal(data, &object); err != nil {
+        t.Fatal(err)
+    }
+    names := make([]string, 0, len(object))
+    for name := range object {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return strings.Join(names, ",")
+}
+
+func TestPaginationNonAlignedOffset(t *testing.T) {
+    tests := []struct {
+        total, offset, limit int
+        page, start, end     int
+    }{
+        {total: 30, offset: 5, limit: 10, page: 1, start: 5, end: 15},
+        {total: 30, offset: 15, limit: 10, page: 2, start: 15, end: 25},
+        {total: 30, offset: 25, limit: 10, page: 3, start: 25, end: 30},
+        {total: 30, offset: 35, limit: 10, page: 4, start: 30, end: 30},
+    }
+    
+    for _, tt := range tests {
+        if got := pageNumber(tt.offset, tt.limit); got != tt.page {
+            t.Errorf("pageNumber(%d, %d) = %d, want %d", tt.offset, tt.limit, got, tt.page)
+        }
+        start, end := pageBounds(tt.total, tt.offset, tt.limit)
+        if start != tt.start || end != tt.end {
+            t.Errorf("pageBounds(%d, %d, %d) = [%d:%d], want [%d:%d]", tt.total, tt.offset, tt.limit, start, end, tt.start, tt.end)
+        }
+    }
+}
+
+func TestPageBoundsWithoutLimit(t *testing.T) {
+    for _, limit := range []int{0, -1, -50} {
+        start, end := pageBounds(30, 5, limit)
+        if start != 5 || end != 30 {
+            t.Errorf("pageBounds(30, 5, %d) = [%d:%d], want everything after offset [5:30]", limit, start, end)
+        }
+        if got := pageNumber(5, limit); got != 1 {
+            t.Errorf("pageNumber(5, %d) = %d, want 1", limit, got)
+        }
+    }
+}
+
+func TestV1ResponseShapeIsFrozen(t *testing.T) {
+    router := newTestHandler(t)
+    envelope := "api_version,data,has_more,limit,page,total"
+    
+    tests := []struct {
+        url    string
+        record string
+    }{
+        {"/metrics/channel?v=1", "channel,clicks,closed_won,cost,cpa,cpc,cpm,ctr,cvr_lead_to_opp,cvr_opp_to_won,date," +
+            "has_crm_data,impressions,impressions_tracked,leads,opportunities,quality_score,recurring_revenue,revenue,roas," +
+            "total_records,valid_records"},
+        {"/metrics/funnel?v=1", "clicks,closed_won,cost,cpa,cpc,cpm,ctr,cvr_lead_to_opp,cvr_opp_to_won," +
+            "impressions,impressions_tracked,leads,opportunities,quality_score,recurring_revenue,revenue,roas," +
+            "total_records,utm_campaign,utm_medium,utm_source,valid_records"},
+    }
+    
+    for _, tt := range tests {
+        status, body := get(router, tt.url)
+        if status != http.StatusOK {
+            t.Fatalf("%s: status %d: %s", tt.url, status, body)
+        }
+        if got := keys(t, body); got != envelope {
+            t.Errorf("%s: envelope keys = %s, want %s", tt.url, got, envelope)
+        }
+        
+        var response struct {
+            Data []json.RawMessage `json:"data"`
+        }
+        if err := json.Unmarshal(body, &response); err != nil {
+            t.Fatal(err)
+        }
+        if len(response.Data) != 1 {
+            t.Fatalf("%s: got %d records, want 1", tt.url, len(response.Data))
+        }
+        if got := keys(t, response.Data[0]); got != tt.record {
+            t.Errorf("%s: record keys = %s, want %s", tt.url, got, tt.record)
+        }
+    }
+}
+
+func TestCompletenessRangeBounds(t *testing.T) {
+    router := newTestHandler(t)
+    
+    tests := []struct {
+        query  string
+        status int
+    }{
+        {"from=2024-01-01&to=2024-12-31", http.StatusOK}, // 366 days
+        {"from=2024-01-01&to=2025-01-01", http.StatusBadRequest},
+        {"from=0001-01-01&to=9999-12-31", http.StatusBadRequest},
+        {"from=2025-08-02&to=2025-08-01", http.StatusBadRequest},
+    }
+    
+    for _, tt := range tests {
+        if status, body := get(router, "/quality/completeness?"+tt.query); status != tt.status {
+            t.Errorf("%s: status %d, want %d: %s", tt.query, status, tt.status, body)
+        }
+    }
+}
+
+func TestExportDateLockSerializesSameDate(t *testing.T) {
+    h := New(&config.Config{}, nil, nil, nil, nil, nil, nil, logrus.New())
+    
+    release := h.lockExportDate("2025-08-01")
+    acquired := make(chan func())
+    go func() { acquired <- h.lockExportDate("2025-08-01") }()
+    
+    // Other dates are not blocked
+    h.lockExportDate("2025-08-02")()
+    
+    select {
+    case <-acquired:
+        t.Fatal("second export of the same date ran concurrently")
+    case <-time.After(20 * time.Millisecond):
+    }
+    
+    release()
+    (<-acquired)()
+    if len(h.exportLocks) != 0 {
+        t.Errorf("%d export locks left after release", len(h.exportLocks))
+    }
+}
+
+func TestDeltaExportWithoutChangesSkipsSink(t *testing.T) {
+    gin.SetMode(gin.TestMode)
+    logger := logrus.New()
+    logger.SetOutput(io.Discard)
+    
+    var hits int32
+    sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&hits, 1)
+    }))
+    defer sink.Close()
+    
+    date := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+    store := storage.NewMemoryStore(0)
+    store.Promote(store.Stage(
+        []models.NormalizedAdsRecord{{Date: date, CampaignID: "C-1", Channel: "google_ads", Clicks: 10, Cost: 5, UTMKey: "k"}},
+        nil,
+    ))
+    
+    cfg := &config.Config{SinkURL: sink.URL, HTTPTimeout: 5 * time.Second, RetryAttempts: 1, ExportRetryAttempts: 1}
+    counters := stats.NewCounters()
+    httpClient := client.NewHTTPClient(cfg, counters, logger)
+    h := New(cfg, httpClient, nil, store, metrics.NewCalculator(cfg), export.NewExporter(cfg, httpClient, logger), counters, logger)
+    
+    router := gin.New()
+    router.POST("/export/run", h.ExportData)
+    
+    var responses [2]struct {
+        Exported     bool `json:"exported"`
+        RecordsCount int  `json:"records_count"`
+        SkippedCount int  `json:"skipped_count"`
+    }
+    for i := range responses {
+        w := httptest.NewRecorder()
+        router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/export/run?date=2025-08-01&delta=true", nil))
+        if w.Code != http.StatusOK {
+            t.Fatalf("export %d: status %d: %s", i+1, w.Code, w.Body)
+        }
+        if err := json.Unmarshal(w.Body.Bytes(), &responses[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    
+    if !responses[0].Exported || responses[0].RecordsCount != 1 {
+        t.Errorf("first export = %+v, want 1 record exported", responses[0])
+    }
+    if responses[1].Exported || responses[1].RecordsCount != 0 || responses[1].SkippedCount != 1 {
+        t.Errorf("unchanged export = %+v, want nothing exported and 1 skipped", responses[1])
+    }
+    if got := atomic.LoadInt32(&hits); got != 1 {
+        t.Errorf("sink called %d times, want 1", got)
+    }
+    if got := counters.Snapshot().TotalExports; got != 1 {
+        t.Errorf("total_exports = %d, want 1", got)
+    }
+}
+
+func TestNonIntegerPaginationRejected(t *testing.T) {
+    router := newTestHandler(t)
+    
+    for _, endpoint := range []string{"/metrics/channel", "/metrics/funnel"} {
+        for _, query := range []string{"limit=1O", "offset=x", "limit=2.5"} {
+            if status, body := get(router, endpoint+"?"+query); status != http.StatusBadRequest {
+                t.Errorf("%s?%s: status %d, want 400: %s", endpoint, query, status, body)
+            }
+        }
+    }
+}
@@ -18,6 +18,7 @@ import (
     "admira-etl/internal/handlers"
     "admira-etl/internal/metrics"
     "admira-etl/internal/export"
+    "admira-etl/internal/stats"
 )
 
 func main() {
@@ -36,14 +37,18 @@ func main() {
     logger.Info("Starting Admira ETL Service with Data Quality Tracking")
     
     // Initialize components
-    httpClient := client.NewHTTPClient(cfg, logger)
-    transformer := transformer.New()
-    store := storage.NewMemoryStore()
-    calculator := metrics.NewCalculator()
-    exporter := export.NewExporter(cfg.SinkSecret, httpClient, logger)
+    counters := stats.NewCounters()
+    httpClient := client.NewHTTPClient(cfg, counters, logger)
+    transformer := transformer.New(cfg)
+    store := storage.NewMemoryStore(cfg.MaxRetainedDuplicates)
+    calculator := metrics.NewCalculator(cfg)
+    if cfg.IncrementalMetrics {
+        store.SetAggregator(calculator.UpdateAggregates)
+    }
+    exporter := export.NewExporter(cfg, httpClient, logger)
     
     // Initialize handlers
-    handler := handlers.New(cfg, httpClient, transformer, store, calculator, exporter, logger)
+    handler := handlers.New(cfg, httpClient, transformer, store, calculator, exporter, counters, logger)
     
     // Setup Gin router
     if cfg.LogLevel != "debug" {
@@ -53,18 +58,32 @@ func main() {
     router.Use(gin.Logger(), gin.Recovery())
     
     // Health endpoints
-    router.GET("/healthz", handler.HealthCheck)
-    router.GET("/readyz", handler.ReadinessCheck)
+    router.GET(cfg.HealthPath, handler.HealthCheck)
+    router.GET(cfg.ReadyPath, handler.ReadinessCheck)
+    
+    // Stats endpoint
+    router.GET("/stats/counters", handler.GetCounters)
     
-    // Ingestion endpoint
+    // Ingestion endpoints
     router.POST("/ingest/run", handler.IngestData)
+    router.GET("/ingest/status", handler.GetIngestStatus)
     
     // Data quality endpoint
     router.GET("/quality/report", handler.GetDataQualityReport)
+    router.GET("/quality/diff", handler.GetDataQualityDiff)
+    router.GET("/quality/completeness", handler.GetDataCompleteness)
+    router.GET("/quality/duplicates", handler.GetDuplicateCollisions)
+    router.POST("/quality/ack", handler.AcknowledgeQualityIssues)
     
     // Metrics endpoints
     router.GET("/metrics/channel", handler.GetChannelMetrics)
+    router.GET("/metrics/channel/distribution", handler.GetChannelDistribution)
     router.GET("/metrics/funnel", handler.GetFunnelMetrics)
+    router.GET("/metrics/crm/hourly", handler.GetCRMHourlyMetrics)
+    router.GET("/metrics/summary", handler.GetMetricsSummary)
+    
+    // Debug endpoints
+    router.GET("/debug/duplicates", handler.GetDuplicates)
     
     // Export endpoint
     router.POST("/export/run", handler.ExportData)
@@ -1,6 +1,8 @@
 package models
 
 import (
+    "bytes"
+    "encoding/json"
     "time"
 )
 
@@ -9,6 +11,17 @@ type FieldQuality struct {
     IsValid     bool   `json:"is_valid"`
     Description string `json:"description"`
     OriginalValue interface{} `json:"original_value,omitempty"`
+    
+    // Set when the validator changed the value, e.g. clamp -5 → 0
+    Transformation *Transformation `json:"transformation,omitempty"`
+}
+
+// Transformation is a machine-readable audit of a value change made during
+// normalization
+type Transformation struct {
+    Action string      `json:"action"` // default, clamp, canonicalize, infer, normalize, trim
+    From   interface{} `json:"from"`
+    To     interface{} `json:"to"`
 }
 
 type RecordQuality struct {
@@ -46,17 +59,53 @@ type AdsRecord struct {
     UTMCampaign  string  `json:"utm_campaign"`
     UTMSource    *string `json:"utm_source"`
     UTMMedium    *string `json:"utm_medium"`
+    
+    // Numeric fields left blank in a CSV row, flagged as missing instead
+    // of read as 0
+    Missing map[string]bool `json:"-"`
 }
 
 type CRMRecord struct {
-    OpportunityID string  `json:"opportunity_id"`
-    ContactEmail  string  `json:"contact_email"`
-    Stage         string  `json:"stage"`
-    Amount        float64 `json:"amount"`
-    CreatedAt     string  `json:"created_at"`
-    UTMCampaign   string  `json:"utm_campaign"`
-    UTMSource     *string `json:"utm_source"`
-    UTMMedium     *string `json:"utm_medium"`
+    OpportunityID string    `json:"opportunity_id"`
+    ContactEmail  string    `json:"contact_email"`
+    Phone         string    `json:"phone"`
+    Stage         string    `json:"stage"`
+    Amount        CRMAmount `json:"amount"`
+    Currency      string    `json:"currency"`
+    MRR           float64   `json:"mrr"`
+    CreatedAt     string    `json:"created_at"`
+    CampaignID    string    `json:"campaign_id"`
+    UTMCampaign   string    `json:"utm_campaign"`
+    UTMSource     *string   `json:"utm_source"`
+    UTMMedium     *string   `json:"utm_medium"`
+    
+    // Numeric fields left blank in a CSV row, flagged as missing instead
+    // of read as 0
+    Missing map[string]bool `json:"-"`
+}
+
+// CRMAmount is a CRM amount sent either flat (100) or as an object carrying
+// its currency ({"value": 100, "currency": "EUR"})
+type CRMAmount struct {
+    Value    float64
+    Currency string
+}
+
+func (a *CRMAmount) UnmarshalJSON(data []byte) error {
+    if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+        return json.Unmarshal(data, &a.Value)
+    }
+    
+    var nested struct {
+        Value    float64 `json:"value"`
+        Currency string  `json:"currency"`
+    }
+    if err := json.Unmarshal(data, &nested); err != nil {
+        return err
+    }
+    a.Value = nested.Value
+    a.Currency = nested.Currency
+    return nil
 }
 
 // Normalized internal structures with Quality Tracking
@@ -79,9 +128,13 @@ type NormalizedAdsRecord struct {
 type NormalizedCRMRecord struct {
     OpportunityID string
     ContactEmail  string
+    Phone         string
     Stage         string
     Amount        float64
+    Currency      string
+    MRR           float64
     CreatedAt     time.Time
+    CampaignID    string
     UTMCampaign   string
     UTMSource     string
     UTMMedium     string
@@ -102,11 +155,30 @@ type ChannelMetrics struct {
     Opportunities int     `json:"opportunities"`
     ClosedWon     int     `json:"closed_won"`
     Revenue       float64 `json:"revenue"`
+    RecurringRevenue float64 `json:"recurring_revenue"`
     CPC           float64 `json:"cpc"`
     CPA           float64 `json:"cpa"`
     CVRLeadToOpp  float64 `json:"cvr_lead_to_opp"`
     CVROppToWon   float64 `json:"cvr_opp_to_won"`
     ROAS          float64 `json:"roas"`
+    AvgDealSize   float64 `json:"avg_deal_size"` // revenue / closed_won
+    
+    // Anomalies flags values the ratios hide, e.g. roas_undefined_zero_cost
+    // when revenue came in with no cost (ROAS reads 0)
+    Anomalies []string `json:"anomalies,omitempty"`
+    
+    // EfficiencyIndex ranks channels: ROAS × (closed_won / clicks)
+    EfficiencyIndex float64 `json:"efficiency_index"`
+    
+    // Impression-derived metrics are null when no record in the group
+    // reports impressions (not tracked, as opposed to 0 impressions)
+    ImpressionsTracked bool     `json:"impressions_tracked"`
+    CTR                *float64 `json:"ctr"`
+    CPM                *float64 `json:"cpm"`
+    
+    // HasCRMData is false when no CRM record matched the group, so zero
+    // conversions mean "no CRM data available" rather than "no conversions".
+    HasCRMData    bool    `json:"has_crm_data"`
     
     // Data Quality Summary
     QualityScore  float64 `json:"quality_score"`  // Percentage of valid records
@@ -115,21 +187,33 @@ type ChannelMetrics struct {
 }
 
 type FunnelMetrics struct {
-    UTMCampaign   string  `json:"utm_campaign"`
-    UTMSource     string  `json:"utm_source"`
-    UTMMedium     string  `json:"utm_medium"`
-    Clicks        int     `json:"clicks"`
-    Impressions   int     `json:"impressions"`
-    Cost          float64 `json:"cost"`
-    Leads         int     `json:"leads"`
-    Opportunities int     `json:"opportunities"`
-    ClosedWon     int     `json:"closed_won"`
-    Revenue       float64 `json:"revenue"`
-    CPC           float64 `json:"cpc"`
-    CPA           float64 `json:"cpa"`
-    CVRLeadToOpp  float64 `json:"cvr_lead_to_opp"`
-    CVROppToWon   float64 `json:"cvr_opp_to_won"`
-    ROAS          float64 `json:"roas"`
+    Channel          string  `json:"channel,omitempty"` // only with funnel_include_channel
+    UTMCampaign      string  `json:"utm_campaign"`
+    UTMSource        string  `json:"utm_source"`
+    UTMMedium        string  `json:"utm_medium"`
+    Clicks           int     `json:"clicks"`
+    Impressions      int     `json:"impressions"`
+    Cost             float64 `json:"cost"`
+    Leads            int     `json:"leads"`
+    Opportunities    int     `json:"opportunities"`
+    ClosedWon        int     `json:"closed_won"`
+    Revenue          float64 `json:"revenue"`
+    RecurringRevenue float64 `json:"recurring_revenue"`
+    CPC              float64 `json:"cpc"`
+    CPA              float64 `json:"cpa"`
+    CVRLeadToOpp     float64 `json:"cvr_lead_to_opp"`
+    CVROppToWon      float64 `json:"cvr_opp_to_won"`
+    ROAS             float64 `json:"roas"`
+    AvgDealSize      float64 `json:"avg_deal_size"` // revenue / closed_won
+    
+    // Anomalies flags values the ratios hide, e.g. roas_undefined_zero_cost
+    Anomalies []string `json:"anomalies,omitempty"`
+    
+    // Impression-derived metrics are null when no record in the group
+    // reports impressions (not tracked, as opposed to 0 impressions)
+    ImpressionsTracked bool     `json:"impressions_tracked"`
+    CTR                *float64 `json:"ctr"`
+    CPM                *float64 `json:"cpm"`
     
     // Data Quality Summary
     QualityScore  float64 `json:"quality_score"`
@@ -137,32 +221,285 @@ type FunnelMetrics struct {
     ValidRecords  int     `json:"valid_records"`
 }
 
+// MetricDistribution summarizes a metric across the daily values of a channel
+type MetricDistribution struct {
+    Channel string  `json:"channel"`
+    Metric  string  `json:"metric"`
+    Days    int     `json:"days"`
+    Min     float64 `json:"min"`
+    P25     float64 `json:"p25"`
+    Median  float64 `json:"median"`
+    P75     float64 `json:"p75"`
+    P95     float64 `json:"p95"`
+    Max     float64 `json:"max"`
+}
+
+// MetricsSummary holds grand totals across all channels for a period
+type MetricsSummary struct {
+    From           string  `json:"from,omitempty"`
+    To             string  `json:"to,omitempty"`
+    TotalCost      float64 `json:"total_cost"`
+    TotalClosedWon int     `json:"total_closed_won"`
+    TotalRevenue   float64 `json:"total_revenue"`
+    
+    // BlendedCAC is total_cost / total_closed_won over every channel, unlike
+    // the per-channel CPA (cost per lead)
+    BlendedCAC float64 `json:"blended_cac"`
+}
+
+// HourlyCRMMetrics aggregates the CRM records created in one UTC hour of day
+type HourlyCRMMetrics struct {
+    Hour          int     `json:"hour"` // 0-23
+    Records       int     `json:"records"`
+    Leads         int     `json:"leads"`
+    Opportunities int     `json:"opportunities"`
+    ClosedWon     int     `json:"closed_won"`
+    Revenue       float64 `json:"revenue"`
+}
+
+// Aggregates are the unfiltered channel and funnel metrics maintained as
+// records are stored (INCREMENTAL_METRICS)
+type Aggregates struct {
+    ChannelMetrics []ChannelMetrics
+    FunnelMetrics  []FunnelMetrics
+    
+    // Running sums the next ingest's changes are applied to, owned by the
+    // calculator
+    State interface{}
+}
+
+// RecordChange is what an ingest changed in the stored records: the records
+// it added and evicted per source, plus the CRM records stored after it
+type RecordChange struct {
+    AddedAds   []NormalizedAdsRecord
+    EvictedAds []NormalizedAdsRecord
+    AddedCRM   []NormalizedCRMRecord
+    EvictedCRM []NormalizedCRMRecord
+    CRMRecords []NormalizedCRMRecord
+}
+
 // Data Quality Report Structures
 type DataQualityReport struct {
     Summary    QualitySummary    `json:"summary"`
     AdsReport  []RecordQuality   `json:"ads_quality"`
     CRMReport  []RecordQuality   `json:"crm_quality"`
     Timestamp  string            `json:"timestamp"`
+    
+    // Occurrences of each unacknowledged issue, uncapped so diffs don't
+    // depend on MAX_COMMON_ISSUES
+    IssueCounts map[string]int `json:"-"`
 }
 
+// QualitySummary scores are null for a dataset without records, so an empty
+// ingest isn't mistaken for 0% valid data. Status is "ok", "partial" (one
+// dataset empty) or "no_data". Severity grades the overall score against
+// QUALITY_CRITICAL_BELOW / QUALITY_WARNING_BELOW: "critical", "warning",
+// "ok", or "no_data" without a score.
 type QualitySummary struct {
-    TotalAdsRecords    int     `json:"total_ads_records"`
-    ValidAdsRecords    int     `json:"valid_ads_records"`
-    AdsQualityScore    float64 `json:"ads_quality_score"`
-    TotalCRMRecords    int     `json:"total_crm_records"`
-    ValidCRMRecords    int     `json:"valid_crm_records"`
-    CRMQualityScore    float64 `json:"crm_quality_score"`
-    OverallQualityScore float64 `json:"overall_quality_score"`
-    CommonIssues       []string `json:"common_issues"`
+    Status              string   `json:"status"`
+    Severity            string   `json:"severity"`
+    TotalAdsRecords     int      `json:"total_ads_records"`
+    ValidAdsRecords     int      `json:"valid_ads_records"`
+    AdsQualityScore     *float64 `json:"ads_quality_score"`
+    TotalCRMRecords     int      `json:"total_crm_records"`
+    ValidCRMRecords     int      `json:"valid_crm_records"`
+    CRMQualityScore     *float64 `json:"crm_quality_score"`
+    OverallQualityScore *float64 `json:"overall_quality_score"`
+    CommonIssues        []string `json:"common_issues"`
+    AcknowledgedIssues  []string `json:"acknowledged_issues"`
+}
+
+// IssueSignature identifies a quality issue independently of the record
+type IssueSignature struct {
+    Field       string `json:"field" binding:"required"`
+    Description string `json:"description" binding:"required"`
+}
+
+type AcknowledgeRequest struct {
+    Issues []IssueSignature `json:"issues" binding:"required,dive"`
+}
+
+type QualityDiff struct {
+    // Deltas are null when either report has no data for the score
+    OverallScoreDelta *float64 `json:"overall_score_delta"`
+    AdsScoreDelta     *float64 `json:"ads_score_delta"`
+    CRMScoreDelta     *float64 `json:"crm_score_delta"`
+    NewIssues         []string `json:"new_issues"`
+    CurrentTimestamp  string   `json:"current_timestamp"`
+    PreviousTimestamp string   `json:"previous_timestamp"`
+}
+
+// DuplicateReport lists, per source, the dedup keys shared by several
+// records of the latest ingest
+type DuplicateReport struct {
+    Ads []DuplicateKey `json:"ads"`
+    CRM []DuplicateKey `json:"crm"`
+}
+
+// DuplicateKey is a dedup key (ads: date|campaign_id|channel[|utm...],
+// CRM: opportunity ID) and how many records shared it
+type DuplicateKey struct {
+    Key     string `json:"key"`
+    Records int    `json:"records"` // including the record kept
+}
+
+// CompletenessReport lists the days in a range that have no records per source
+type CompletenessReport struct {
+    From            string   `json:"from"`
+    To              string   `json:"to"`
+    ExpectedDays    int      `json:"expected_days"`
+    MissingAdsDates []string `json:"missing_ads_dates"`
+    MissingCRMDates []string `json:"missing_crm_dates"`
 }
 
 // API response structures
 type MetricsResponse struct {
+    Data       interface{}  `json:"data"`
+    Total      int          `json:"total"`
+    Page       int          `json:"page"`
+    Limit      int          `json:"limit"`
+    Offset     int          `json:"offset"`
+    HasMore    bool         `json:"has_more"`
+    Meta       *MetricsMeta `json:"meta,omitempty"` // v2+
+    APIVersion int          `json:"api_version"`
+}
+
+// MetricsMeta echoes the date window and filters that produced a response.
+// From and To are empty when no date range was applied.
+type MetricsMeta struct {
+    From    string            `json:"from"`
+    To      string            `json:"to"`
+    Filters map[string]string `json:"filters"`
+}
+
+// MetricsResponseV1 is the frozen v1 envelope served to clients pinned with
+// ?v=1; fields added to MetricsResponse later must not appear here.
+type MetricsResponseV1 struct {
     Data       interface{} `json:"data"`
     Total      int         `json:"total"`
     Page       int         `json:"page"`
     Limit      int         `json:"limit"`
     HasMore    bool        `json:"has_more"`
+    APIVersion int         `json:"api_version"`
+}
+
+// V1 returns the response in the v1 shape, carrying data already converted
+// to v1 records
+func (r MetricsResponse) V1(data interface{}) MetricsResponseV1 {
+    return MetricsResponseV1{
+        Data:       data,
+        Total:      r.Total,
+        Page:       r.Page,
+        Limit:      r.Limit,
+        HasMore:    r.HasMore,
+        APIVersion: r.APIVersion,
+    }
+}
+
+// ChannelMetricsV1 is the frozen v1 shape of ChannelMetrics
+type ChannelMetricsV1 struct {
+    Channel            string   `json:"channel"`
+    Date               string   `json:"date"`
+    Clicks             int      `json:"clicks"`
+    Impressions        int      `json:"impressions"`
+    Cost               float64  `json:"cost"`
+    Leads              int      `json:"leads"`
+    Opportunities      int      `json:"opportunities"`
+    ClosedWon          int      `json:"closed_won"`
+    Revenue            float64  `json:"revenue"`
+    RecurringRevenue   float64  `json:"recurring_revenue"`
+    CPC                float64  `json:"cpc"`
+    CPA                float64  `json:"cpa"`
+    CVRLeadToOpp       float64  `json:"cvr_lead_to_opp"`
+    CVROppToWon        float64  `json:"cvr_opp_to_won"`
+    ROAS               float64  `json:"roas"`
+    ImpressionsTracked bool     `json:"impressions_tracked"`
+    CTR                *float64 `json:"ctr"`
+    CPM                *float64 `json:"cpm"`
+    HasCRMData         bool     `json:"has_crm_data"`
+    QualityScore       float64  `json:"quality_score"`
+    TotalRecords       int      `json:"total_records"`
+    ValidRecords       int      `json:"valid_records"`
+}
+
+func (m ChannelMetrics) V1() ChannelMetricsV1 {
+    return ChannelMetricsV1{
+        Channel:            m.Channel,
+        Date:               m.Date,
+        Clicks:             m.Clicks,
+        Impressions:        m.Impressions,
+        Cost:               m.Cost,
+        Leads:              m.Leads,
+        Opportunities:      m.Opportunities,
+        ClosedWon:          m.ClosedWon,
+        Revenue:            m.Revenue,
+        RecurringRevenue:   m.RecurringRevenue,
+        CPC:                m.CPC,
+        CPA:                m.CPA,
+        CVRLeadToOpp:       m.CVRLeadToOpp,
+        CVROppToWon:        m.CVROppToWon,
+        ROAS:               m.ROAS,
+        ImpressionsTracked: m.ImpressionsTracked,
+        CTR:                m.CTR,
+        CPM:                m.CPM,
+        HasCRMData:         m.HasCRMData,
+        QualityScore:       m.QualityScore,
+        TotalRecords:       m.TotalRecords,
+        ValidRecords:       m.ValidRecords,
+    }
+}
+
+// FunnelMetricsV1 is the frozen v1 shape of FunnelMetrics
+type FunnelMetricsV1 struct {
+    UTMCampaign        string   `json:"utm_campaign"`
+    UTMSource          string   `json:"utm_source"`
+    UTMMedium          string   `json:"utm_medium"`
+    Clicks             int      `json:"clicks"`
+    Impressions        int      `json:"impressions"`
+    Cost               float64  `json:"cost"`
+    Leads              int      `json:"leads"`
+    Opportunities      int      `json:"opportunities"`
+    ClosedWon          int      `json:"closed_won"`
+    Revenue            float64  `json:"revenue"`
+    RecurringRevenue   float64  `json:"recurring_revenue"`
+    CPC                float64  `json:"cpc"`
+    CPA                float64  `json:"cpa"`
+    CVRLeadToOpp       float64  `json:"cvr_lead_to_opp"`
+    CVROppToWon        float64  `json:"cvr_opp_to_won"`
+    ROAS               float64  `json:"roas"`
+    ImpressionsTracked bool     `json:"impressions_tracked"`
+    CTR                *float64 `json:"ctr"`
+    CPM                *float64 `json:"cpm"`
+    QualityScore       float64  `json:"quality_score"`
+    TotalRecords       int      `json:"total_records"`
+    ValidRecords       int      `json:"valid_records"`
+}
+
+func (m FunnelMetrics) V1() FunnelMetricsV1 {
+    return FunnelMetricsV1{
+        UTMCampaign:        m.UTMCampaign,
+        UTMSource:          m.UTMSource,
+        UTMMedium:          m.UTMMedium,
+        Clicks:             m.Clicks,
+        Impressions:        m.Impressions,
+        Cost:               m.Cost,
+        Leads:              m.Leads,
+        Opportunities:      m.Opportunities,
+        ClosedWon:          m.ClosedWon,
+        Revenue:            m.Revenue,
+        RecurringRevenue:   m.RecurringRevenue,
+        CPC:                m.CPC,
+        CPA:                m.CPA,
+        CVRLeadToOpp:       m.CVRLeadToOpp,
+        CVROppToWon:        m.CVROppToWon,
+        ROAS:               m.ROAS,
+        ImpressionsTracked: m.ImpressionsTracked,
+        CTR:                m.CTR,
+        CPM:                m.CPM,
+        QualityScore:       m.QualityScore,
+        TotalRecords:       m.TotalRecords,
+        ValidRecords:       m.ValidRecords,
+    }
 }
 
 type IngestResponse struct {
@@ -171,15 +508,52 @@ type IngestResponse struct {
     CRMRecords    int    `json:"crm_records"`
     ProcessedAt   string `json:"processed_at"`
     Message       string `json:"message"`
+    SampleRate    float64 `json:"sample_rate"`
+    Sources       []string `json:"sources"`
     
     // Data Quality Summary
     QualitySummary QualitySummary `json:"quality_summary"`
+    
+    // CRM records dropped as likely re-sends (CRM_NEAR_DUPLICATE_WINDOW)
+    CRMNearDuplicates int `json:"crm_near_duplicates"`
+    
+    // Days whose channel cost exceeded its DAILY_CAP_<channel>
+    BudgetAlerts []BudgetAlert `json:"budget_alerts,omitempty"`
+    
+    // Per-date outcomes of the export chained after ingest (AUTO_EXPORT)
+    AutoExport []AutoExportResult `json:"auto_export,omitempty"`
+    
+    // Checksum of the stored data after this ingest, and whether it differs
+    // from the one before
+    Checksum    string `json:"checksum"`
+    DataChanged bool   `json:"data_changed"`
+    
+    // False when the ingest scored below PROMOTE_MIN_QUALITY_SCORE and the
+    // previous data was kept
+    Promoted bool `json:"promoted"`
+}
+
+// BudgetAlert flags a day's channel cost above its configured daily cap,
+// a sign of overspend or a billing error
+type BudgetAlert struct {
+    Date    string  `json:"date"`
+    Channel string  `json:"channel"`
+    Cap     float64 `json:"cap"`
+    Cost    float64 `json:"cost"`
+}
+
+// AutoExportResult is the outcome of exporting one ingested date
+type AutoExportResult struct {
+    Date         string `json:"date"`
+    Status       string `json:"status"` // exported, skipped or failed
+    RecordsCount int    `json:"records_count"`
+    Error        string `json:"error,omitempty"`
 }
 
 type ExportRecord struct {
     Date          string  `json:"date"`
     Channel       string  `json:"channel"`
-    CampaignID    string  `json:"campaign_id"`
+    CampaignID    string  `json:"campaign_id,omitempty"` // omitted when EXPORT_AGG_CAMPAIGN_LABEL=none
     Clicks        int     `json:"clicks"`
     Impressions   int     `json:"impressions"`
     Cost          float64 `json:"cost"`
@@ -187,9 +561,50 @@ type ExportRecord struct {
     Opportunities int     `json:"opportunities"`
     ClosedWon     int     `json:"closed_won"`
     Revenue       float64 `json:"revenue"`
+    RecurringRevenue float64 `json:"recurring_revenue"`
     CPC           float64 `json:"cpc"`
     CPA           float64 `json:"cpa"`
     CVRLeadToOpp  float64 `json:"cvr_lead_to_opp"`
     CVROppToWon   float64 `json:"cvr_opp_to_won"`
     ROAS          float64 `json:"roas"`
+    AvgDealSize   float64 `json:"avg_deal_size"`
+    
+    // Data quality behind the aggregate, only set when EXPORT_INCLUDE_QUALITY is enabled
+    QualityScore *float64 `json:"quality_score,omitempty"`
+    TotalRecords *int     `json:"total_records,omitempty"`
+    ValidRecords *int     `json:"valid_records,omitempty"`
+}
+
+// RawAdsExportRecord is a normalized ads record exported with level=raw
+type RawAdsExportRecord struct {
+    RecordType  string  `json:"record_type"` // always "ads"
+    RecordID    string  `json:"record_id"`
+    Date        string  `json:"date"`
+    CampaignID  string  `json:"campaign_id"`
+    Channel     string  `json:"channel"`
+    Clicks      int     `json:"clicks"`
+    Impressions int     `json:"impressions"`
+    Cost        float64 `json:"cost"`
+    UTMCampaign string  `json:"utm_campaign"`
+    UTMSource   string  `json:"utm_source"`
+    UTMMedium   string  `json:"utm_medium"`
+    IsValid     bool    `json:"is_valid"`
+}
+
+// RawCRMExportRecord is a normalized CRM record exported with level=raw
+type RawCRMExportRecord struct {
+    RecordType    string  `json:"record_type"` // always "crm"
+    RecordID      string  `json:"record_id"`
+    OpportunityID string  `json:"opportunity_id"`
+    ContactEmail  string  `json:"contact_email"`
+    Stage         string  `json:"stage"`
+    Amount        float64 `json:"amount"`
+    Currency      string  `json:"currency,omitempty"`
+    MRR           float64 `json:"mrr"`
+    CreatedAt     string  `json:"created_at"`
+    CampaignID    string  `json:"campaign_id,omitempty"`
+    UTMCampaign   string  `json:"utm_campaign"`
+    UTMSource     string  `json:"utm_source"`
+    UTMMedium     string  `json:"utm_medium"`
+    IsValid       bool    `json:"is_valid"`
 }
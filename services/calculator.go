@@ -1,16 +1,52 @@
 package metrics
 
 import (
+    "fmt"
     "math"
+    "sort"
+    "strings"
     "time"
     
+    "admira-etl/internal/config"
     "admira-etl/internal/models"
 )
 
-type Calculator struct{}
+// Calculator derives business metrics from normalized records.
+//
+// Opportunity definition: a CRM record counts toward Opportunities when its
+// stage is "opportunity" or "closed_won", plus "closed_lost" when
+// countLostAsOpportunity is set (the default). Each record is counted once.
+// Leads are records in the "lead" stage only.
+//
+// CRM records join ads groups by UTM key. With matchBy "campaign_id", CRM
+// records carrying a campaign ID join on it instead, falling back to UTM.
+//
+// Channel metrics also require the CRM record to be created on the ads date,
+// or up to matchWindowDays later, since conversions lag the click. With a
+// window, one CRM record can count toward several consecutive ads days.
+type Calculator struct {
+    countLostAsOpportunity bool
+    matchBy                string
+    matchWindowDays        int
+    dailyCaps              map[string]float64
+}
+
+func NewCalculator(cfg *config.Config) *Calculator {
+    return &Calculator{
+        countLostAsOpportunity: cfg.CountLostAsOpportunity,
+        matchBy:                cfg.MatchBy,
+        matchWindowDays:        cfg.ChannelMatchWindowDays,
+        dailyCaps:              cfg.DailyCaps,
+    }
+}
 
-func NewCalculator() *Calculator {
-    return &Calculator{}
+// matchesGroup reports whether a CRM record converts for an ads group with
+// the given UTM keys and campaign IDs, counted per ads record.
+func (c *Calculator) matchesGroup(crmRecord models.NormalizedCRMRecord, utmKeys, campaignIDs map[string]int) bool {
+    if c.matchBy == "campaign_id" && crmRecord.CampaignID != "" {
+        return campaignIDs[crmRecord.CampaignID] > 0
+    }
+    return utmKeys[crmRecord.UTMKey] > 0
 }
 
 func (c *Calculator) CalculateChannelMetrics(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord, channel string) []models.ChannelMetrics {
@@ -25,154 +61,492 @@ func (c *Calculator) CalculateChannelMetrics(adsRecords []models.NormalizedAdsRe
     
     var results []models.ChannelMetrics
     
-    for key, adsGroup := range adsGrouped {
+    for _, adsGroup := range adsGrouped {
         if len(adsGroup) == 0 {
             continue
         }
         
         date := adsGroup[0].Date.Format("2006-01-02")
-        channelName := adsGroup[0].Channel
+        groupDate := time.Date(adsGroup[0].Date.Year(), adsGroup[0].Date.Month(), adsGroup[0].Date.Day(), 0, 0, 0, 0, time.UTC)
+        windowEnd := groupDate.AddDate(0, 0, c.matchWindowDays)
         
         // Aggregate ads metrics
-        totalClicks := 0
-        totalImpressions := 0
-        totalCost := 0.0
-        utmKeys := make(map[string]bool)
+        var ads adsTotals
+        utmKeys := make(map[string]int)
+        campaignIDs := make(map[string]int)
         
         for _, record := range adsGroup {
-            totalClicks += record.Clicks
-            totalImpressions += record.Impressions
-            totalCost += record.Cost
-            utmKeys[record.UTMKey] = true
+            ads.add(record, 1)
+            utmKeys[record.UTMKey]++
+            campaignIDs[record.CampaignID]++
         }
         
         // Find matching CRM records
-        leads := 0
-        opportunities := 0
-        closedWon := 0
-        revenue := 0.0
-        
+        var crm crmTotals
         for _, crmRecord := range crmRecords {
-            recordDate := crmRecord.CreatedAt.Format("2006-01-02")
-            if recordDate == date && utmKeys[crmRecord.UTMKey] {
-                switch crmRecord.Stage {
-                case "lead":
-                    leads++
-                case "opportunity":
-                    opportunities++
-                case "closed_won":
-                    closedWon++
-                    revenue += crmRecord.Amount
-                case "closed_lost":
-                    // Count as opportunity that didn't convert
-                    opportunities++
-                }
+            recordDate := time.Date(crmRecord.CreatedAt.Year(), crmRecord.CreatedAt.Month(), crmRecord.CreatedAt.Day(), 0, 0, 0, 0, time.UTC)
+            inWindow := !recordDate.Before(groupDate) && !recordDate.After(windowEnd)
+            if inWindow && c.matchesGroup(crmRecord, utmKeys, campaignIDs) {
+                c.addCRM(&crm, crmRecord, 1)
             }
         }
         
-        // Calculate business metrics
-        metrics := models.ChannelMetrics{
-            Channel:       channelName,
-            Date:          date,
-            Clicks:        totalClicks,
-            Impressions:   totalImpressions,
-            Cost:          totalCost,
-            Leads:         leads,
-            Opportunities: opportunities + closedWon, // Total opportunities including won
-            ClosedWon:     closedWon,
-            Revenue:       revenue,
-            CPC:           c.safeDivide(totalCost, float64(totalClicks)),
-            CPA:           c.safeDivide(totalCost, float64(leads)),
-            CVRLeadToOpp:  c.safeDivide(float64(opportunities+closedWon), float64(leads)),
-            CVROppToWon:   c.safeDivide(float64(closedWon), float64(opportunities+closedWon)),
-            ROAS:          c.safeDivide(revenue, totalCost),
+        results = append(results, c.channelMetrics(date, adsGroup[0].Channel, ads, crm))
+    }
+    
+    return results
+}
+
+// SortChannelMetrics orders metrics by the given metric, highest first, with
+// ties broken by date and channel.
+func (c *Calculator) SortChannelMetrics(metrics []models.ChannelMetrics, metric string) error {
+    if _, err := c.metricValue(models.ChannelMetrics{}, metric); err != nil {
+        return err
+    }
+    
+    sort.SliceStable(metrics, func(i, j int) bool {
+        a, _ := c.metricValue(metrics[i], metric)
+        b, _ := c.metricValue(metrics[j], metric)
+        if a != b {
+            return a > b
+        }
+        if metrics[i].Date != metrics[j].Date {
+            return metrics[i].Date < metrics[j].Date
+        }
+        return metrics[i].Channel < metrics[j].Channel
+    })
+    return nil
+}
+
+// CalculateChannelMetricsWithQuality calculates channel metrics and attaches
+// the data quality summary of the ads records behind each date/channel group.
+func (c *Calculator) CalculateChannelMetricsWithQuality(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord, channel string) []models.ChannelMetrics {
+    results := c.CalculateChannelMetrics(adsRecords, crmRecords, channel)
+    
+    for i := range results {
+        total := 0
+        valid := 0
+        for _, record := range adsRecords {
+            if record.Date.Format("2006-01-02") == results[i].Date && record.Channel == results[i].Channel {
+                total++
+                if record.Quality.IsValid {
+                    valid++
+                }
+            }
         }
         
-        results = append(results, metrics)
+        results[i].TotalRecords = total
+        results[i].ValidRecords = valid
+        results[i].QualityScore = c.safeDivide(float64(valid)*100, float64(total))
     }
     
     return results
 }
 
-func (c *Calculator) CalculateFunnelMetrics(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord, utmCampaign string) []models.FunnelMetrics {
+// CheckDailyCaps compares each day's cost per channel against the channel's
+// daily cap and returns the breaches, ordered by date and channel.
+func (c *Calculator) CheckDailyCaps(adsRecords []models.NormalizedAdsRecord) []models.BudgetAlert {
+    if len(c.dailyCaps) == 0 {
+        return nil
+    }
+    
+    costs := make(map[string]float64)
+    for _, record := range adsRecords {
+        if _, capped := c.dailyCaps[record.Channel]; capped {
+            costs[record.Date.Format("2006-01-02")+"|"+record.Channel] += record.Cost
+        }
+    }
+    
+    var alerts []models.BudgetAlert
+    for key, cost := range costs {
+        parts := strings.SplitN(key, "|", 2)
+        dailyCap := c.dailyCaps[parts[1]]
+        if cost > dailyCap {
+            alerts = append(alerts, models.BudgetAlert{
+                Date:    parts[0],
+                Channel: parts[1],
+                Cap:     dailyCap,
+                Cost:    math.Round(cost*100) / 100,
+            })
+        }
+    }
+    
+    sort.Slice(alerts, func(i, j int) bool {
+        if alerts[i].Date != alerts[j].Date {
+            return alerts[i].Date < alerts[j].Date
+        }
+        return alerts[i].Channel < alerts[j].Channel
+    })
+    return alerts
+}
+
+// CalculateChannelDistribution summarizes how a metric is distributed across
+// the daily channel metrics of one channel, to help spot outlier days.
+func (c *Calculator) CalculateChannelDistribution(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord, channel string, metric string) (models.MetricDistribution, error) {
+    if _, err := c.metricValue(models.ChannelMetrics{}, metric); err != nil {
+        return models.MetricDistribution{}, err
+    }
+    
+    var values []float64
+    for _, daily := range c.CalculateChannelMetrics(adsRecords, crmRecords, channel) {
+        value, _ := c.metricValue(daily, metric)
+        values = append(values, value)
+    }
+    
+    distribution := models.MetricDistribution{
+        Channel: channel,
+        Metric:  metric,
+        Days:    len(values),
+    }
+    if len(values) == 0 {
+        return distribution, nil
+    }
+    
+    sort.Float64s(values)
+    distribution.Min = values[0]
+    distribution.P25 = c.percentile(values, 0.25)
+    distribution.Median = c.percentile(values, 0.5)
+    distribution.P75 = c.percentile(values, 0.75)
+    distribution.P95 = c.percentile(values, 0.95)
+    distribution.Max = values[len(values)-1]
+    
+    return distribution, nil
+}
+
+func (c *Calculator) metricValue(metrics models.ChannelMetrics, metric string) (float64, error) {
+    switch metric {
+    case "cost":
+        return metrics.Cost, nil
+    case "clicks":
+        return float64(metrics.Clicks), nil
+    case "impressions":
+        return float64(metrics.Impressions), nil
+    case "leads":
+        return float64(metrics.Leads), nil
+    case "revenue":
+        return metrics.Revenue, nil
+    case "cpc":
+        return metrics.CPC, nil
+    case "cpa":
+        return metrics.CPA, nil
+    case "roas":
+        return metrics.ROAS, nil
+    case "avg_deal_size":
+        return metrics.AvgDealSize, nil
+    case "efficiency_index":
+        return metrics.EfficiencyIndex, nil
+    default:
+        return 0, fmt.Errorf("unsupported metric: %s", metric)
+    }
+}
+
+// percentile interpolates linearly between the closest ranks of sorted values
+func (c *Calculator) percentile(sorted []float64, p float64) float64 {
+    rank := p * float64(len(sorted)-1)
+    lower := int(math.Floor(rank))
+    upper := int(math.Ceil(rank))
+    value := sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+    return math.Round(value*1000) / 1000
+}
+
+// CalculateSummary totals cost over all ads records and closed-won deals
+// over all CRM records, whatever their channel or attribution.
+func (c *Calculator) CalculateSummary(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord) models.MetricsSummary {
+    var summary models.MetricsSummary
+    for _, record := range adsRecords {
+        summary.TotalCost += record.Cost
+    }
+    for _, record := range crmRecords {
+        if record.Stage == "closed_won" {
+            summary.TotalClosedWon++
+            summary.TotalRevenue += record.Amount
+        }
+    }
+    
+    summary.BlendedCAC = c.safeDivide(summary.TotalCost, float64(summary.TotalClosedWon))
+    return summary
+}
+
+// CalculateHourlyCRMMetrics buckets CRM records by the UTC hour of
+// CreatedAt, always returning all 24 hours. Opportunities follow the same
+// definition as channel metrics. Records without a valid CreatedAt are
+// skipped.
+func (c *Calculator) CalculateHourlyCRMMetrics(crmRecords []models.NormalizedCRMRecord) []models.HourlyCRMMetrics {
+    hours := make([]models.HourlyCRMMetrics, 24)
+    for hour := range hours {
+        hours[hour].Hour = hour
+    }
+    
+    for _, record := range crmRecords {
+        if record.CreatedAt.IsZero() {
+            continue
+        }
+        
+        bucket := &hours[record.CreatedAt.UTC().Hour()]
+        bucket.Records++
+        switch record.Stage {
+        case "lead":
+            bucket.Leads++
+        case "opportunity":
+            bucket.Opportunities++
+        case "closed_won":
+            bucket.Opportunities++
+            bucket.ClosedWon++
+            bucket.Revenue += record.Amount
+        case "closed_lost":
+            if c.countLostAsOpportunity {
+                bucket.Opportunities++
+            }
+        }
+    }
+    
+    return hours
+}
+
+// unknownUTMKey groups records with no UTM campaign, source or medium
+const unknownUTMKey = "unknown|unknown|unknown"
+
+// CalculateFunnelMetrics groups by UTM key, and by channel too when
+// includeChannel is set. CRM records carry no channel, so a UTM key shared by
+// several channels credits its CRM conversions to each channel's row.
+// excludeUnknownUTM drops the group of records with no UTM tags at all.
+func (c *Calculator) CalculateFunnelMetrics(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord, utmCampaign string, includeChannel, excludeUnknownUTM bool) []models.FunnelMetrics {
     // Group by UTM parameters
     utmGroups := make(map[string][]models.NormalizedAdsRecord)
     
     for _, record := range adsRecords {
+        if excludeUnknownUTM && record.UTMKey == unknownUTMKey {
+            continue
+        }
         if utmCampaign == "" || record.UTMCampaign == utmCampaign {
             key := record.UTMKey
+            if includeChannel {
+                key += "|" + record.Channel
+            }
             utmGroups[key] = append(utmGroups[key], record)
         }
     }
     
     var results []models.FunnelMetrics
     
-    for utmKey, adsGroup := range utmGroups {
+    for _, adsGroup := range utmGroups {
         if len(adsGroup) == 0 {
             continue
         }
+        utmKey := adsGroup[0].UTMKey
+        groupChannel := ""
+        if includeChannel {
+            groupChannel = adsGroup[0].Channel
+        }
         
         // Aggregate ads metrics
-        totalClicks := 0
-        totalImpressions := 0
-        totalCost := 0.0
-        
-        campaign := adsGroup[0].UTMCampaign
-        source := adsGroup[0].UTMSource
-        medium := adsGroup[0].UTMMedium
-        
+        var ads adsTotals
+        campaignIDs := make(map[string]int)
         for _, record := range adsGroup {
-            totalClicks += record.Clicks
-            totalImpressions += record.Impressions
-            totalCost += record.Cost
+            ads.add(record, 1)
+            campaignIDs[record.CampaignID]++
         }
+        utmKeys := map[string]int{utmKey: 1}
         
         // Find matching CRM records
-        leads := 0
-        opportunities := 0
-        closedWon := 0
-        revenue := 0.0
-        
+        var crm crmTotals
         for _, crmRecord := range crmRecords {
-            if crmRecord.UTMKey == utmKey {
-                switch crmRecord.Stage {
-                case "lead":
-                    leads++
-                case "opportunity":
-                    opportunities++
-                case "closed_won":
-                    closedWon++
-                    revenue += crmRecord.Amount
-                case "closed_lost":
-                    opportunities++
-                }
+            if c.matchesGroup(crmRecord, utmKeys, campaignIDs) {
+                c.addCRM(&crm, crmRecord, 1)
             }
         }
         
-        metrics := models.FunnelMetrics{
-            UTMCampaign:   campaign,
-            UTMSource:     source,
-            UTMMedium:     medium,
-            Clicks:        totalClicks,
-            Impressions:   totalImpressions,
-            Cost:          totalCost,
-            Leads:         leads,
-            Opportunities: opportunities + closedWon,
-            ClosedWon:     closedWon,
-            Revenue:       revenue,
-            CPC:           c.safeDivide(totalCost, float64(totalClicks)),
-            CPA:           c.safeDivide(totalCost, float64(leads)),
-            CVRLeadToOpp:  c.safeDivide(float64(opportunities+closedWon), float64(leads)),
-            CVROppToWon:   c.safeDivide(float64(closedWon), float64(opportunities+closedWon)),
-            ROAS:          c.safeDivide(revenue, totalCost),
+        first := adsGroup[0]
+        results = append(results, c.funnelMetrics(groupChannel, first.UTMCampaign, first.UTMSource, first.UTMMedium, ads, crm))
+    }
+    
+    return results
+}
+
+// CalculateFunnelMetricsWithQuality calculates funnel metrics and attaches
+// the data quality summary of the ads records behind each UTM group.
+func (c *Calculator) CalculateFunnelMetricsWithQuality(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord, utmCampaign string, includeChannel, excludeUnknownUTM bool) []models.FunnelMetrics {
+    results := c.CalculateFunnelMetrics(adsRecords, crmRecords, utmCampaign, includeChannel, excludeUnknownUTM)
+    
+    for i := range results {
+        total := 0
+        valid := 0
+        for _, record := range adsRecords {
+            if record.UTMCampaign == results[i].UTMCampaign &&
+               record.UTMSource == results[i].UTMSource &&
+               record.UTMMedium == results[i].UTMMedium &&
+               (!includeChannel || record.Channel == results[i].Channel) {
+                total++
+                if record.Quality.IsValid {
+                    valid++
+                }
+            }
         }
         
-        results = append(results, metrics)
+        results[i].TotalRecords = total
+        results[i].ValidRecords = valid
+        results[i].QualityScore = c.safeDivide(float64(valid)*100, float64(total))
     }
     
     return results
 }
 
+// adsTotals are the summed ads quantities of a group
+type adsTotals struct {
+    clicks      int
+    impressions int
+    cost        float64
+}
+
+// add adds a record to the totals, or removes it with sign -1
+func (t *adsTotals) add(record models.NormalizedAdsRecord, sign int) {
+    t.clicks += sign * record.Clicks
+    t.impressions += sign * record.Impressions
+    t.cost += float64(sign) * record.Cost
+}
+
+// crmTotals are the summed conversions of the CRM records matching a group.
+// opportunities leave out closed_won, which the metrics add back.
+type crmTotals struct {
+    matched          int
+    leads            int
+    opportunities    int
+    closedWon        int
+    revenue          float64
+    recurringRevenue float64
+}
+
+// addCRM adds a matching CRM record to the totals, or removes it with sign -1
+func (c *Calculator) addCRM(t *crmTotals, record models.NormalizedCRMRecord, sign int) {
+    t.matched += sign
+    switch record.Stage {
+    case "lead":
+        t.leads += sign
+    case "opportunity":
+        t.opportunities += sign
+    case "closed_won":
+        t.closedWon += sign
+        t.revenue += float64(sign) * record.Amount
+        t.recurringRevenue += float64(sign) * record.MRR
+    case "closed_lost":
+        // Count as opportunity that didn't convert
+        if c.countLostAsOpportunity {
+            t.opportunities += sign
+        }
+    }
+}
+
+// channelMetrics derives a channel/date group's metrics from its totals
+func (c *Calculator) channelMetrics(date, channel string, ads adsTotals, crm crmTotals) models.ChannelMetrics {
+    // Calculate business metrics from the group's summed base quantities
+    ratios := c.ratios(ads.clicks, ads.cost, crm.leads, crm.opportunities+crm.closedWon, crm.closedWon, crm.revenue)
+    
+    metrics := models.ChannelMetrics{
+        Channel:            channel,
+        Date:               date,
+        Clicks:             ads.clicks,
+        Impressions:        ads.impressions,
+        Cost:               ads.cost,
+        Leads:              crm.leads,
+        Opportunities:      crm.opportunities + crm.closedWon, // Total opportunities including won
+        ClosedWon:          crm.closedWon,
+        Revenue:            crm.revenue,
+        RecurringRevenue:   crm.recurringRevenue,
+        CPC:                ratios.cpc,
+        CPA:                ratios.cpa,
+        CVRLeadToOpp:       ratios.cvrLeadToOpp,
+        CVROppToWon:        ratios.cvrOppToWon,
+        ROAS:               ratios.roas,
+        AvgDealSize:        ratios.avgDealSize,
+        Anomalies:          ratios.anomalies,
+        HasCRMData:         crm.matched > 0,
+        ImpressionsTracked: ads.impressions > 0,
+        CTR:                c.impressionRatio(float64(ads.clicks), ads.impressions),
+        CPM:                c.impressionRatio(ads.cost*1000, ads.impressions),
+    }
+    
+    metrics.EfficiencyIndex = c.safeDivide(metrics.ROAS*float64(crm.closedWon), float64(ads.clicks))
+    return metrics
+}
+
+// funnelMetrics derives a UTM group's metrics from its totals
+func (c *Calculator) funnelMetrics(channel, campaign, source, medium string, ads adsTotals, crm crmTotals) models.FunnelMetrics {
+    ratios := c.ratios(ads.clicks, ads.cost, crm.leads, crm.opportunities+crm.closedWon, crm.closedWon, crm.revenue)
+    
+    return models.FunnelMetrics{
+        Channel:            channel,
+        UTMCampaign:        campaign,
+        UTMSource:          source,
+        UTMMedium:          medium,
+        Clicks:             ads.clicks,
+        Impressions:        ads.impressions,
+        Cost:               ads.cost,
+        Leads:              crm.leads,
+        Opportunities:      crm.opportunities + crm.closedWon,
+        ClosedWon:          crm.closedWon,
+        Revenue:            crm.revenue,
+        RecurringRevenue:   crm.recurringRevenue,
+        CPC:                ratios.cpc,
+        CPA:                ratios.cpa,
+        CVRLeadToOpp:       ratios.cvrLeadToOpp,
+        CVROppToWon:        ratios.cvrOppToWon,
+        ROAS:               ratios.roas,
+        AvgDealSize:        ratios.avgDealSize,
+        Anomalies:          ratios.anomalies,
+        ImpressionsTracked: ads.impressions > 0,
+        CTR:                c.impressionRatio(float64(ads.clicks), ads.impressions),
+        CPM:                c.impressionRatio(ads.cost*1000, ads.impressions),
+    }
+}
+
+// ratioMetrics are the ratio KPIs of an aggregated group
+type ratioMetrics struct {
+    cpc          float64
+    cpa          float64
+    cvrLeadToOpp float64
+    cvrOppToWon  float64
+    roas         float64
+    avgDealSize  float64
+    anomalies    []string
+}
+
+// ratios computes ratio KPIs from a group's summed base quantities, where
+// opportunities already include closed_won. Aggregations must always go
+// through here: averaging per-day ratios weights a 1-click day like a
+// 1000-click one and gives wrong totals.
+func (c *Calculator) ratios(clicks int, cost float64, leads, opportunities, closedWon int, revenue float64) ratioMetrics {
+    return ratioMetrics{
+        cpc:          c.safeDivide(cost, float64(clicks)),
+        cpa:          c.safeDivide(cost, float64(leads)),
+        cvrLeadToOpp: c.safeDivide(float64(opportunities), float64(leads)),
+        cvrOppToWon:  c.safeDivide(float64(closedWon), float64(opportunities)),
+        roas:         c.safeDivide(revenue, cost),
+        avgDealSize:  c.safeDivide(revenue, float64(closedWon)),
+        anomalies:    c.anomalies(cost, revenue),
+    }
+}
+
+// anomalies flags group values that a ratio alone would hide: revenue with
+// no cost makes ROAS undefined, yet safeDivide reports it as 0.
+func (c *Calculator) anomalies(cost, revenue float64) []string {
+    var anomalies []string
+    if cost == 0 && revenue > 0 {
+        anomalies = append(anomalies, "roas_undefined_zero_cost")
+    }
+    return anomalies
+}
+
+// impressionRatio divides by impressions, returning nil when the group has
+// no impressions at all: channels such as email don't track them, so an
+// impression-based metric is not applicable rather than 0.
+func (c *Calculator) impressionRatio(numerator float64, impressions int) *float64 {
+    if impressions == 0 {
+        return nil
+    }
+    ratio := c.safeDivide(numerator, float64(impressions))
+    return &ratio
+}
+
 func (c *Calculator) safeDivide(numerator, denominator float64) float64 {
     if denominator == 0 {
         return 0
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+    "testing"
+    "time"
+    
+    "admira-etl/internal/config"
+    "admira-etl/internal/models"
+)
+
+func TestCalculateChannelMetricsCountLostAsOpportunity(t *testing.T) {
+    date := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+    ads := []models.NormalizedAdsRecord{
+        {Date: date, CampaignID: "C-1", Channel: "google_ads", Clicks: 50, Impressions: 1000, Cost: 100, UTMKey: "k"},
+    }
+    crm := []models.NormalizedCRMRecord{
+        {OpportunityID: "O-1", Stage: "lead", CreatedAt: date, UTMKey: "k"},
+        {OpportunityID: "O-2", Stage: "lead", CreatedAt: date, UTMKey: "k"},
+        {OpportunityID: "O-3", Stage: "opportunity", CreatedAt: date, UTMKey: "k"},
+        {OpportunityID: "O-4", Stage: "closed_won", Amount: 300, CreatedAt: date, UTMKey: "k"},
+        {OpportunityID: "O-5", Stage: "closed_lost", Amount: 200, CreatedAt: date, UTMKey: "k"},
+    }
+    
+    tests := []struct {
+        name          string
+        countLost     bool
+        opportunities int
+        cvrLeadToOpp  float64
+        cvrOppToWon   float64
+    }{
+        {"lost counted", true, 3, 1.5, 0.333},
+        {"lost ignored", false, 2, 1, 0.5},
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            calc := NewCalculator(&config.Config{CountLostAsOpportunity: tt.countLost})
+            
+            results := calc.CalculateChannelMetrics(ads, crm, "")
+            if len(results) != 1 {
+                t.Fatalf("got %d channel metrics, want 1", len(results))
+            }
+            got := results[0]
+            
+            if got.Leads != 2 || got.ClosedWon != 1 || got.Revenue != 300 {
+                t.Errorf("leads/closed_won/revenue = %d/%d/%v, want 2/1/300", got.Leads, got.ClosedWon, got.Revenue)
+            }
+            if got.Opportunities != tt.opportunities {
+                t.Errorf("opportunities = %d, want %d", got.Opportunities, tt.opportunities)
+            }
+            if got.CVRLeadToOpp != tt.cvrLeadToOpp {
+                t.Errorf("cvr_lead_to_opp = %v, want %v", got.CVRLeadToOpp, tt.cvrLeadToOpp)
+            }
+            if got.CVROppToWon != tt.cvrOppToWon {
+                t.Errorf("cvr_opp_to_won = %v, want %v", got.CVROppToWon, tt.cvrOppToWon)
+            }
+            if got.CPA != 50 || got.ROAS != 3 {
+                t.Errorf("cpa/roas = %v/%v, want 50/3", got.CPA, got.ROAS)
+            }
+        })
+    }
+}
+
+func TestFunnelRatiosRecomputedFromSums(t *testing.T) {
+    day1 := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+    day2 := day1.AddDate(0, 0, 1)
+    ads := []models.NormalizedAdsRecord{
+        {Date: day1, CampaignID: "C-1", Channel: "google_ads", Clicks: 1, Cost: 10, UTMKey: "k"},
+        {Date: day2, CampaignID: "C-1", Channel: "google_ads", Clicks: 999, Cost: 990, UTMKey: "k"},
+    }
+    crm := []models.NormalizedCRMRecord{
+        {OpportunityID: "O-1", Stage: "lead", CreatedAt: day1, UTMKey: "k"},
+    }
+    for i := 0; i < 9; i++ {
+        crm = append(crm, models.NormalizedCRMRecord{OpportunityID: "O-2", Stage: "lead", CreatedAt: day2, UTMKey: "k"})
+    }
+    calc := NewCalculator(&config.Config{})
+    
+    // Per-day ratios, whose plain average is the wrong answer
+    var naiveCPC, naiveCPA float64
+    daily := calc.CalculateChannelMetrics(ads, crm, "")
+    for _, day := range daily {
+        naiveCPC += day.CPC / float64(len(daily))
+        naiveCPA += day.CPA / float64(len(daily))
+    }
+    
+    funnel := calc.CalculateFunnelMetrics(ads, crm, "", false, false)
+    if len(funnel) != 1 {
+        t.Fatalf("got %d funnel groups, want 1", len(funnel))
+    }
+    
+    // cost 1000 over 1000 clicks and 10 leads
+    if funnel[0].CPC != 1 || funnel[0].CPA != 100 {
+        t.Errorf("cpc/cpa = %v/%v, want 1/100", funnel[0].CPC, funnel[0].CPA)
+    }
+    if funnel[0].CPC == naiveCPC || funnel[0].CPA == naiveCPA {
+        t.Errorf("cpc/cpa = %v/%v match the naive per-day average", funnel[0].CPC, funnel[0].CPA)
+    }
+}
+
+func TestRatiosFromSummedQuantities(t *testing.T) {
+    calc := NewCalculator(&config.Config{})
+    
+    // A 1-click day at cpc 10 and a 999-click day at cpc 0.991 average to
+    // 5.495; the summed quantities give 1000/1000
+    got := calc.ratios(1+999, 10+990, 1+9, 2+3, 1+1, 100+1900)
+    
+    want := ratioMetrics{cpc: 1, cpa: 100, cvrLeadToOpp: 0.5, cvrOppToWon: 0.4, roas: 2, avgDealSize: 1000}
+    if got.cpc != want.cpc || got.cpa != want.cpa || got.cvrLeadToOpp != want.cvrLeadToOpp ||
+       got.cvrOppToWon != want.cvrOppToWon || got.roas != want.roas || got.avgDealSize != want.avgDealSize {
+        t.Errorf("ratios = %+v, want %+v", got, want)
+    }
+}
+
+func TestChannelDistributionRejectsUnknownMetricWithoutDays(t *testing.T) {
+    calc := NewCalculator(&config.Config{})
+    
+    if _, err := calc.CalculateChannelDistribution(nil, nil, "google_ads", "bogus"); err == nil {
+        t.Error("expected an error for an unsupported metric with no days")
+    }
+}
@@ -0,0 +1,390 @@
+package metrics
+
+import (
+    "sort"
+    "time"
+    
+    "admira-etl/internal/models"
+)
+
+// aggregateState holds the running totals behind incremental aggregates.
+// Records added or evicted by an ingest adjust the totals of their groups;
+// only a group whose UTM keys or campaign IDs changed rescans the CRM
+// records, since the change decides which conversions match it.
+//
+// States are never modified once published: an update copies the group
+// maps and clones each group before its first change.
+type aggregateState struct {
+    channels      map[string]*channelGroup    // by date|channel
+    funnels       map[string]*funnelGroup     // by UTM key
+    channelCounts map[string]int              // ads records per channel
+    triples       map[utmTriple]qualityTotals // funnel quality is per UTM values
+}
+
+// utmTriple is the campaign, source and medium a funnel row reports
+type utmTriple struct {
+    campaign string
+    source   string
+    medium   string
+}
+
+type qualityTotals struct {
+    total int
+    valid int
+}
+
+func (q *qualityTotals) add(record models.NormalizedAdsRecord, sign int) {
+    q.total += sign
+    if record.Quality.IsValid {
+        q.valid += sign
+    }
+}
+
+type channelGroup struct {
+    date        time.Time
+    channel     string
+    ads         adsTotals
+    quality     qualityTotals
+    utmKeys     map[string]int
+    campaignIDs map[string]int
+    crm         crmTotals
+}
+
+func (g *channelGroup) clone() *channelGroup {
+    clone := *g
+    clone.utmKeys = copyCounts(g.utmKeys)
+    clone.campaignIDs = copyCounts(g.campaignIDs)
+    return &clone
+}
+
+type funnelGroup struct {
+    records     int
+    ads         adsTotals
+    triples     map[utmTriple]int
+    campaignIDs map[string]int
+    crm         crmTotals
+}
+
+func (g *funnelGroup) clone() *funnelGroup {
+    clone := *g
+    clone.triples = make(map[utmTriple]int, len(g.triples))
+    for triple, count := range g.triples {
+        clone.triples[triple] = count
+    }
+    clone.campaignIDs = copyCounts(g.campaignIDs)
+    return &clone
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+    copied := make(map[string]int, len(counts))
+    for key, count := range counts {
+        copied[key] = count
+    }
+    return copied
+}
+
+// count adjusts a multiset, reporting whether key entered or left it
+func count(counts map[string]int, key string, sign int) bool {
+    counts[key] += sign
+    if counts[key] == 0 {
+        delete(counts, key)
+        return true
+    }
+    return counts[key] == sign
+}
+
+// day truncates t to its calendar date in UTC, as the channel matching does
+func day(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// aggregateUpdate applies one ingest's changes to a copy of a state
+type aggregateUpdate struct {
+    calc  *Calculator
+    state *aggregateState
+    
+    // Groups already cloned into state, which may be changed in place
+    clonedChannels map[string]bool
+    clonedFunnels  map[string]bool
+    
+    // Groups whose CRM totals are rebuilt from scratch at the end
+    rescanChannels map[string]bool
+    rescanFunnels  map[string]bool
+}
+
+// UpdateAggregates applies the records an ingest added and evicted to the
+// current aggregates, nil before the first ingest. The results match
+// CalculateChannelMetricsWithQuality and CalculateFunnelMetricsWithQuality
+// over the whole store, except that a funnel group mixing UTM sources
+// (inferred from CHANNEL_DEFAULT_SOURCES) reports the alphabetically first
+// one rather than that of its first record.
+func (c *Calculator) UpdateAggregates(current *models.Aggregates, change models.RecordChange) *models.Aggregates {
+    state := &aggregateState{
+        channels:      make(map[string]*channelGroup),
+        funnels:       make(map[string]*funnelGroup),
+        channelCounts: make(map[string]int),
+        triples:       make(map[utmTriple]qualityTotals),
+    }
+    if current != nil {
+        if previous, ok := current.State.(*aggregateState); ok {
+            for key, group := range previous.channels {
+                state.channels[key] = group
+            }
+            for key, group := range previous.funnels {
+                state.funnels[key] = group
+            }
+            state.channelCounts = copyCounts(previous.channelCounts)
+            for triple, quality := range previous.triples {
+                state.triples[triple] = quality
+            }
+        }
+    }
+    
+    u := &aggregateUpdate{
+        calc:           c,
+        state:          state,
+        clonedChannels: make(map[string]bool),
+        clonedFunnels:  make(map[string]bool),
+        rescanChannels: make(map[string]bool),
+        rescanFunnels:  make(map[string]bool),
+    }
+    for _, record := range change.EvictedAds {
+        u.addAds(record, -1)
+    }
+    for _, record := range change.AddedAds {
+        u.addAds(record, 1)
+    }
+    for _, record := range change.EvictedCRM {
+        u.addCRM(record, -1)
+    }
+    for _, record := range change.AddedCRM {
+        u.addCRM(record, 1)
+    }
+    u.rescan(change.CRMRecords)
+    
+    return &models.Aggregates{
+        ChannelMetrics: c.aggregateChannelMetrics(state),
+        FunnelMetrics:  c.aggregateFunnelMetrics(state),
+        State:          state,
+    }
+}
+
+// channel returns the group at key, cloned so it can be changed, creating
+// it when missing
+func (u *aggregateUpdate) channel(key string, date time.Time, channel string) *channelGroup {
+    group, ok := u.state.channels[key]
+    switch {
+    case !ok:
+        group = &channelGroup{
+            date:        date,
+            channel:     channel,
+            utmKeys:     make(map[string]int),
+            campaignIDs: make(map[string]int),
+        }
+        u.rescanChannels[key] = true
+    case !u.clonedChannels[key]:
+        group = group.clone()
+    default:
+        return group
+    }
+    u.state.channels[key] = group
+    u.clonedChannels[key] = true
+    return group
+}
+
+// funnel is channel for funnel groups
+func (u *aggregateUpdate) funnel(utmKey string) *funnelGroup {
+    group, ok := u.state.funnels[utmKey]
+    switch {
+    case !ok:
+        group = &funnelGroup{
+            triples:     make(map[utmTriple]int),
+            campaignIDs: make(map[string]int),
+        }
+        u.rescanFunnels[utmKey] = true
+    case !u.clonedFunnels[utmKey]:
+        group = group.clone()
+    default:
+        return group
+    }
+    u.state.funnels[utmKey] = group
+    u.clonedFunnels[utmKey] = true
+    return group
+}
+
+// addAds adds an ads record to its channel and funnel groups, or evicts it
+// with sign -1
+func (u *aggregateUpdate) addAds(record models.NormalizedAdsRecord, sign int) {
+    byCampaign := u.calc.matchBy == "campaign_id"
+    
+    key := record.Date.Format("2006-01-02") + "|" + record.Channel
+    channel := u.channel(key, day(record.Date), record.Channel)
+    channel.ads.add(record, sign)
+    channel.quality.add(record, sign)
+    keysChanged := count(channel.utmKeys, record.UTMKey, sign)
+    if count(channel.campaignIDs, record.CampaignID, sign) && byCampaign {
+        keysChanged = true
+    }
+    if keysChanged {
+        u.rescanChannels[key] = true
+    }
+    if channel.quality.total == 0 {
+        delete(u.state.channels, key)
+    }
+    count(u.state.channelCounts, record.Channel, sign)
+    
+    triple := utmTriple{record.UTMCampaign, record.UTMSource, record.UTMMedium}
+    funnel := u.funnel(record.UTMKey)
+    funnel.records += sign
+    funnel.ads.add(record, sign)
+    funnel.triples[triple] += sign
+    if funnel.triples[triple] == 0 {
+        delete(funnel.triples, triple)
+    }
+    if count(funnel.campaignIDs, record.CampaignID, sign) && byCampaign {
+        u.rescanFunnels[record.UTMKey] = true
+    }
+    if funnel.records == 0 {
+        delete(u.state.funnels, record.UTMKey)
+    }
+    
+    quality := u.state.triples[triple]
+    quality.add(record, sign)
+    if quality.total == 0 {
+        delete(u.state.triples, triple)
+    } else {
+        u.state.triples[triple] = quality
+    }
+}
+
+// addCRM adds a CRM record to the groups it converts for, or evicts it with
+// sign -1. Groups due for a rescan are skipped.
+func (u *aggregateUpdate) addCRM(record models.NormalizedCRMRecord, sign int) {
+    // A record matches the channel groups dated up to matchWindowDays before it
+    recordDate := day(record.CreatedAt)
+    for offset := 0; offset <= u.calc.matchWindowDays; offset++ {
+        date := recordDate.AddDate(0, 0, -offset)
+        for channelName := range u.state.channelCounts {
+            key := date.Format("2006-01-02") + "|" + channelName
+            group, ok := u.state.channels[key]
+            if !ok || u.rescanChannels[key] || !u.calc.matchesGroup(record, group.utmKeys, group.campaignIDs) {
+                continue
+            }
+            u.calc.addCRM(&u.channel(key, group.date, group.channel).crm, record, sign)
+        }
+    }
+    
+    var utmKeys []string
+    if u.calc.matchBy == "campaign_id" && record.CampaignID != "" {
+        for utmKey, group := range u.state.funnels {
+            if group.campaignIDs[record.CampaignID] > 0 {
+                utmKeys = append(utmKeys, utmKey)
+            }
+        }
+    } else if _, ok := u.state.funnels[record.UTMKey]; ok {
+        utmKeys = append(utmKeys, record.UTMKey)
+    }
+    for _, utmKey := range utmKeys {
+        if !u.rescanFunnels[utmKey] {
+            u.calc.addCRM(&u.funnel(utmKey).crm, record, sign)
+        }
+    }
+}
+
+// rescan rebuilds the CRM totals of the groups whose matching changed from
+// the CRM records now stored
+func (u *aggregateUpdate) rescan(crmRecords []models.NormalizedCRMRecord) {
+    for key := range u.rescanChannels {
+        group, ok := u.state.channels[key]
+        if !ok {
+            continue // emptied by evictions
+        }
+        group = u.channel(key, group.date, group.channel)
+        group.crm = crmTotals{}
+        
+        windowEnd := group.date.AddDate(0, 0, u.calc.matchWindowDays)
+        for _, record := range crmRecords {
+            recordDate := day(record.CreatedAt)
+            inWindow := !recordDate.Before(group.date) && !recordDate.After(windowEnd)
+            if inWindow && u.calc.matchesGroup(record, group.utmKeys, group.campaignIDs) {
+                u.calc.addCRM(&group.crm, record, 1)
+            }
+        }
+    }
+    
+    for utmKey := range u.rescanFunnels {
+        if _, ok := u.state.funnels[utmKey]; !ok {
+            continue
+        }
+        group := u.funnel(utmKey)
+        group.crm = crmTotals{}
+        
+        utmKeys := map[string]int{utmKey: 1}
+        for _, record := range crmRecords {
+            if u.calc.matchesGroup(record, utmKeys, group.campaignIDs) {
+                u.calc.addCRM(&group.crm, record, 1)
+            }
+        }
+    }
+}
+
+// aggregateChannelMetrics lists the channel metrics of a state by date and
+// channel
+func (c *Calculator) aggregateChannelMetrics(state *aggregateState) []models.ChannelMetrics {
+    results := make([]models.ChannelMetrics, 0, len(state.channels))
+    for _, group := range state.channels {
+        metrics := c.channelMetrics(group.date.Format("2006-01-02"), group.channel, group.ads, group.crm)
+        metrics.TotalRecords = group.quality.total
+        metrics.ValidRecords = group.quality.valid
+        metrics.QualityScore = c.safeDivide(float64(group.quality.valid)*100, float64(group.quality.total))
+        results = append(results, metrics)
+    }
+    
+    sort.Slice(results, func(i, j int) bool {
+        if results[i].Date != results[j].Date {
+            return results[i].Date < results[j].Date
+        }
+        return results[i].Channel < results[j].Channel
+    })
+    return results
+}
+
+// aggregateFunnelMetrics lists the funnel metrics of a state by UTM key
+func (c *Calculator) aggregateFunnelMetrics(state *aggregateState) []models.FunnelMetrics {
+    utmKeys := make([]string, 0, len(state.funnels))
+    for utmKey := range state.funnels {
+        utmKeys = append(utmKeys, utmKey)
+    }
+    sort.Strings(utmKeys)
+    
+    results := make([]models.FunnelMetrics, 0, len(utmKeys))
+    for _, utmKey := range utmKeys {
+        group := state.funnels[utmKey]
+        
+        var triple utmTriple
+        first := true
+        for candidate := range group.triples {
+            if first || lessTriple(candidate, triple) {
+                triple, first = candidate, false
+            }
+        }
+        
+        metrics := c.funnelMetrics("", triple.campaign, triple.source, triple.medium, group.ads, group.crm)
+        quality := state.triples[triple]
+        metrics.TotalRecords = quality.total
+        metrics.ValidRecords = quality.valid
+        metrics.QualityScore = c.safeDivide(float64(quality.valid)*100, float64(quality.total))
+        results = append(results, metrics)
+    }
+    return results
+}
+
+func lessTriple(a, b utmTriple) bool {
+    if a.campaign != b.campaign {
+        return a.campaign < b.campaign
+    }
+    if a.source != b.source {
+        return a.source < b.source
+    }
+    return a.medium < b.medium
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+    "reflect"
+    "sort"
+    "testing"
+    "time"
+    
+    "admira-etl/internal/config"
+    "admira-etl/internal/models"
+    "admira-etl/internal/storage"
+)
+
+func adsRecord(date, channel, campaignID, source string, clicks int, cost float64, valid bool) models.NormalizedAdsRecord {
+    day, _ := time.Parse("2006-01-02", date)
+    return models.NormalizedAdsRecord{
+        Date:        day,
+        CampaignID:  campaignID,
+        Channel:     channel,
+        Clicks:      clicks,
+        Impressions: clicks * 10,
+        Cost:        cost,
+        UTMCampaign: "summer",
+        UTMSource:   source,
+        UTMMedium:   "cpc",
+        UTMKey:      "summer|" + source + "|cpc",
+        Quality:     models.RecordQuality{IsValid: valid},
+    }
+}
+
+func crmRecord(id, date, stage, campaignID, source string, amount float64) models.NormalizedCRMRecord {
+    created, _ := time.Parse("2006-01-02", date)
+    return models.NormalizedCRMRecord{
+        OpportunityID: id,
+        Stage:         stage,
+        Amount:        amount,
+        CreatedAt:     created.Add(9 * time.Hour),
+        CampaignID:    campaignID,
+        UTMCampaign:   "summer",
+        UTMSource:     source,
+        UTMMedium:     "cpc",
+        UTMKey:        "summer|" + source + "|cpc",
+    }
+}
+
+// onDemand computes the metrics the aggregates must match, in their order
+func onDemand(calc *Calculator, store *storage.MemoryStore) ([]models.ChannelMetrics, []models.FunnelMetrics) {
+    channel := calc.CalculateChannelMetricsWithQuality(store.GetAdsRecords(), store.GetCRMRecords(), "")
+    sort.Slice(channel, func(i, j int) bool {
+        if channel[i].Date != channel[j].Date {
+            return channel[i].Date < channel[j].Date
+        }
+        return channel[i].Channel < channel[j].Channel
+    })
+    
+    funnel := calc.CalculateFunnelMetricsWithQuality(store.GetAdsRecords(), store.GetCRMRecords(), "", false, false)
+    sort.Slice(funnel, func(i, j int) bool {
+        return funnel[i].UTMSource < funnel[j].UTMSource
+    })
+    return channel, funnel
+}
+
+func TestIncrementalAggregatesMatchOnDemand(t *testing.T) {
+    ingests := []struct {
+        name string
+        ads  []models.NormalizedAdsRecord
+        crm  []models.NormalizedCRMRecord
+    }{
+        {
+            name: "initial",
+            ads: []models.NormalizedAdsRecord{
+                adsRecord("2025-08-01", "google_ads", "C-1", "google", 10, 10.5, true),
+                adsRecord("2025-08-01", "google_ads", "C-2", "newsletter", 20, 4.25, true),
+                adsRecord("2025-08-02", "meta_ads", "C-3", "meta", 5, 2.5, false),
+            },
+            crm: []models.NormalizedCRMRecord{
+                crmRecord("O-1", "2025-08-01", "lead", "C-1", "google", 0),
+                crmRecord("O-2", "2025-08-02", "closed_won", "C-2", "newsletter", 100),
+                crmRecord("O-3", "2025-08-02", "opportunity", "C-3", "meta", 0),
+                crmRecord("O-4", "2025-08-03", "closed_lost", "C-1", "google", 0),
+            },
+        },
+        {
+            // Evicts the only newsletter ads row, so the google_ads group
+            // loses a UTM key, adds a new day and swaps CRM records
+            name: "insert and evict",
+            ads: []models.NormalizedAdsRecord{
+                adsRecord("2025-08-01", "google_ads", "C-1", "google", 10, 10.5, true),
+                adsRecord("2025-08-02", "meta_ads", "C-3", "meta", 5, 2.5, false),
+                adsRecord("2025-08-03", "google_ads", "C-1", "google", 7, 1.5, true),
+            },
+            crm: []models.NormalizedCRMRecord{
+                crmRecord("O-2", "2025-08-02", "closed_won", "C-2", "newsletter", 100),
+                crmRecord("O-3", "2025-08-02", "opportunity", "C-3", "meta", 0),
+                crmRecord("O-4", "2025-08-03", "closed_lost", "C-1", "google", 0),
+                crmRecord("O-5", "2025-08-02", "closed_won", "C-3", "meta", 250.5),
+            },
+        },
+        {
+            name: "evict a whole group",
+            ads: []models.NormalizedAdsRecord{
+                adsRecord("2025-08-01", "google_ads", "C-1", "google", 10, 10.5, true),
+                adsRecord("2025-08-03", "google_ads", "C-1", "google", 7, 1.5, true),
+            },
+            crm: []models.NormalizedCRMRecord{
+                crmRecord("O-3", "2025-08-02", "opportunity", "C-3", "meta", 0),
+                crmRecord("O-4", "2025-08-03", "closed_lost", "C-1", "google", 0),
+                crmRecord("O-5", "2025-08-02", "closed_won", "C-3", "meta", 250.5),
+            },
+        },
+    }
+    
+    for _, matchBy := range []string{"utm", "campaign_id"} {
+        calc := NewCalculator(&config.Config{CountLostAsOpportunity: true, MatchBy: matchBy, ChannelMatchWindowDays: 1})
+        store := storage.NewMemoryStore(0)
+        store.SetAggregator(calc.UpdateAggregates)
+        
+        for _, ingest := range ingests {
+            store.Promote(store.Stage(ingest.ads, ingest.crm))
+            
+            aggregates := store.GetAggregates()
+            wantChannel, wantFunnel := onDemand(calc, store)
+            if !reflect.DeepEqual(aggregates.ChannelMetrics, wantChannel) {
+                t.Errorf("%s/%s: channel aggregates\n got %+v\nwant %+v", matchBy, ingest.name, aggregates.ChannelMetrics, wantChannel)
+            }
+            if !reflect.DeepEqual(aggregates.FunnelMetrics, wantFunnel) {
+                t.Errorf("%s/%s: funnel aggregates\n got %+v\nwant %+v", matchBy, ingest.name, aggregates.FunnelMetrics, wantFunnel)
+            }
+        }
+    }
+}
@@ -0,0 +1,47 @@
+package stats
+
+import (
+    "sync/atomic"
+)
+
+// Counters holds lightweight process-wide totals. They are safe for
+// concurrent use and reset on restart.
+type Counters struct {
+    ingests          atomic.Int64
+    recordsProcessed atomic.Int64
+    exports          atomic.Int64
+    fetchErrors      atomic.Int64
+}
+
+type Snapshot struct {
+    TotalIngests          int64 `json:"total_ingests"`
+    TotalRecordsProcessed int64 `json:"total_records_processed"`
+    TotalExports          int64 `json:"total_exports"`
+    TotalFetchErrors      int64 `json:"total_fetch_errors"`
+}
+
+func NewCounters() *Counters {
+    return &Counters{}
+}
+
+func (c *Counters) RecordIngest(records int) {
+    c.ingests.Add(1)
+    c.recordsProcessed.Add(int64(records))
+}
+
+func (c *Counters) RecordExport() {
+    c.exports.Add(1)
+}
+
+func (c *Counters) RecordFetchError() {
+    c.fetchErrors.Add(1)
+}
+
+func (c *Counters) Snapshot() Snapshot {
+    return Snapshot{
+        TotalIngests:          c.ingests.Load(),
+        TotalRecordsProcessed: c.recordsProcessed.Load(),
+        TotalExports:          c.exports.Load(),
+        TotalFetchErrors:      c.fetchErrors.Load(),
+    }
+}
@@ -1,66 +1,247 @@
 package storage
 
 import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "sort"
     "sync"
+    "sync/atomic"
     "time"
     
     "admira-etl/internal/models"
 )
 
-type MemoryStore struct {
-    mu         sync.RWMutex
+// snapshot is an immutable view of the store. Writers build a new snapshot
+// off to the side and swap it in atomically, so readers never wait on an
+// ingest; they keep using the snapshot they loaded.
+type snapshot struct {
     adsRecords []models.NormalizedAdsRecord
     crmRecords []models.NormalizedCRMRecord
     lastIngest time.Time
+    checksum   string // over adsRecords and crmRecords, see Checksum
+    
+    // Quality reports of the latest two ingests, for regression diffing
+    currentReport  *models.DataQualityReport
+    previousReport *models.DataQualityReport
+    
+    // Duplicates dropped by the latest ingest, capped at maxDuplicates each
+    adsDuplicates []models.NormalizedAdsRecord
+    crmDuplicates []models.NormalizedCRMRecord
+    
+    // Dedup keys shared by several records, per source
+    duplicateReport models.DuplicateReport
+    
+    // Metrics precomputed from adsRecords and crmRecords, nil unless an
+    // aggregator is set
+    aggregates *models.Aggregates
+}
+
+type MemoryStore struct {
+    writeMu       sync.Mutex // serializes writers; readers never take it
+    data          atomic.Pointer[snapshot]
+    maxDuplicates int
+    aggregate     Aggregator
+}
+
+// Aggregator maintains precomputed metrics across ingests: it applies the
+// records an ingest added and evicted to the current aggregates (nil before
+// the first ingest) and returns the result, leaving current untouched.
+type Aggregator func(current *models.Aggregates, change models.RecordChange) *models.Aggregates
+
+func NewMemoryStore(maxDuplicates int) *MemoryStore {
+    s := &MemoryStore{maxDuplicates: maxDuplicates}
+    s.data.Store(&snapshot{
+        adsRecords:    make([]models.NormalizedAdsRecord, 0),
+        crmRecords:    make([]models.NormalizedCRMRecord, 0),
+        adsDuplicates: make([]models.NormalizedAdsRecord, 0),
+        crmDuplicates: make([]models.NormalizedCRMRecord, 0),
+        checksum:      checksum(nil, nil),
+        
+        duplicateReport: models.DuplicateReport{
+            Ads: make([]models.DuplicateKey, 0),
+            CRM: make([]models.DuplicateKey, 0),
+        },
+    })
+    return s
 }
 
-func NewMemoryStore() *MemoryStore {
-    return &MemoryStore{
-        adsRecords: make([]models.NormalizedAdsRecord, 0),
-        crmRecords: make([]models.NormalizedCRMRecord, 0),
+// SetAggregator makes Stage maintain metrics with aggregate, published in
+// the same snapshot as the records. Set it before serving.
+func (s *MemoryStore) SetAggregator(aggregate Aggregator) {
+    s.aggregate = aggregate
+}
+
+// update applies fn to a copy of the current snapshot and publishes it.
+func (s *MemoryStore) update(fn func(next *snapshot)) {
+    s.writeMu.Lock()
+    defer s.writeMu.Unlock()
+    
+    next := *s.data.Load()
+    fn(&next)
+    s.data.Store(&next)
+}
+
+// Staging is an ingest prepared off to the side of the live data: the
+// dataset with its derived checksum and aggregates, plus the quality and
+// duplicate reports that go with it. Promote publishes all of it in one swap;
+// a staging that is never promoted is simply discarded.
+type Staging struct {
+    adsRecords []models.NormalizedAdsRecord
+    crmRecords []models.NormalizedCRMRecord
+    checksum   string
+    aggregates *models.Aggregates
+    
+    qualityReport   *models.DataQualityReport
+    duplicateReport *models.DuplicateReport
+    
+    // Retained duplicates, nil keeps the current ones
+    adsDuplicates []models.NormalizedAdsRecord
+    crmDuplicates []models.NormalizedCRMRecord
+    
+    maxDuplicates int
+}
+
+// Checksum is the checksum the store will report once the dataset is promoted
+func (st *Staging) Checksum() string {
+    return st.checksum
+}
+
+func (st *Staging) SetQualityReport(report models.DataQualityReport) {
+    st.qualityReport = &report
+}
+
+func (st *Staging) SetDuplicateReport(report models.DuplicateReport) {
+    st.duplicateReport = &report
+}
+
+// SetAdsDuplicates retains up to maxDuplicates dropped ads records
+func (st *Staging) SetAdsDuplicates(records []models.NormalizedAdsRecord) {
+    if len(records) > st.maxDuplicates {
+        records = records[:st.maxDuplicates]
+    }
+    st.adsDuplicates = append([]models.NormalizedAdsRecord{}, records...)
+}
+
+// SetCRMDuplicates retains up to maxDuplicates dropped CRM records
+func (st *Staging) SetCRMDuplicates(records []models.NormalizedCRMRecord) {
+    if len(records) > st.maxDuplicates {
+        records = records[:st.maxDuplicates]
     }
+    st.crmDuplicates = append([]models.NormalizedCRMRecord{}, records...)
 }
 
-func (s *MemoryStore) StoreAdsRecords(records []models.NormalizedAdsRecord) {
-    s.mu.Lock()
-    defer s.mu.Unlock()
+// Stage prepares a replacement dataset without touching the live one;
+// readers keep seeing the current data until Promote. With an aggregator,
+// the aggregates are updated from the records added and evicted relative
+// to the live data. Ingests are serialized by the caller, so the live data
+// is still current when the staging is promoted.
+func (s *MemoryStore) Stage(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord) *Staging {
+    adsKeys := adsRecordKeys(adsRecords)
+    crmKeys := crmRecordKeys(crmRecords)
     
-    s.adsRecords = records
-    s.lastIngest = time.Now()
+    staging := &Staging{
+        adsRecords:    adsRecords,
+        crmRecords:    crmRecords,
+        checksum:      checksum(adsKeys, crmKeys),
+        maxDuplicates: s.maxDuplicates,
+    }
+    if s.aggregate != nil {
+        current := s.data.Load()
+        change := models.RecordChange{CRMRecords: crmRecords}
+        change.AddedAds, change.EvictedAds = diffRecords(current.adsRecords, adsRecords, adsRecordKeys(current.adsRecords), adsKeys)
+        change.AddedCRM, change.EvictedCRM = diffRecords(current.crmRecords, crmRecords, crmRecordKeys(current.crmRecords), crmKeys)
+        staging.aggregates = s.aggregate(current.aggregates, change)
+    }
+    return staging
+}
+
+// diffRecords compares two versions of a dataset by record key, returning
+// the records only in next (added) and only in previous (evicted). Repeated
+// records are matched one for one.
+func diffRecords[T any](previous, next []T, previousKeys, nextKeys []string) (added, evicted []T) {
+    remaining := make(map[string]int, len(previousKeys))
+    for _, key := range previousKeys {
+        remaining[key]++
+    }
+    for i, key := range nextKeys {
+        if remaining[key] > 0 {
+            remaining[key]--
+            continue
+        }
+        added = append(added, next[i])
+    }
+    for i, key := range previousKeys {
+        if remaining[key] > 0 {
+            remaining[key]--
+            evicted = append(evicted, previous[i])
+        }
+    }
+    return added, evicted
+}
+
+// Promote atomically replaces the live data with a staged ingest, so readers
+// never see new records next to the previous ingest's reports.
+func (s *MemoryStore) Promote(staging *Staging) {
+    s.update(func(next *snapshot) {
+        next.adsRecords = staging.adsRecords
+        next.crmRecords = staging.crmRecords
+        next.checksum = staging.checksum
+        next.aggregates = staging.aggregates
+        next.lastIngest = time.Now()
+        
+        if staging.qualityReport != nil {
+            next.previousReport = next.currentReport
+            next.currentReport = staging.qualityReport
+        }
+        if staging.duplicateReport != nil {
+            next.duplicateReport = *staging.duplicateReport
+        }
+        if staging.adsDuplicates != nil {
+            next.adsDuplicates = staging.adsDuplicates
+        }
+        if staging.crmDuplicates != nil {
+            next.crmDuplicates = staging.crmDuplicates
+        }
+    })
 }
 
-func (s *MemoryStore) StoreCRMRecords(records []models.NormalizedCRMRecord) {
-    s.mu.Lock()
-    defer s.mu.Unlock()
+func (s *MemoryStore) GetDuplicates() ([]models.NormalizedAdsRecord, []models.NormalizedCRMRecord) {
+    data := s.data.Load()
     
-    s.crmRecords = records
+    ads := make([]models.NormalizedAdsRecord, len(data.adsDuplicates))
+    copy(ads, data.adsDuplicates)
+    crm := make([]models.NormalizedCRMRecord, len(data.crmDuplicates))
+    copy(crm, data.crmDuplicates)
+    return ads, crm
+}
+
+func (s *MemoryStore) GetDuplicateReport() models.DuplicateReport {
+    return s.data.Load().duplicateReport
 }
 
 func (s *MemoryStore) GetAdsRecords() []models.NormalizedAdsRecord {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
+    data := s.data.Load()
     
-    records := make([]models.NormalizedAdsRecord, len(s.adsRecords))
-    copy(records, s.adsRecords)
+    records := make([]models.NormalizedAdsRecord, len(data.adsRecords))
+    copy(records, data.adsRecords)
     return records
 }
 
 func (s *MemoryStore) GetCRMRecords() []models.NormalizedCRMRecord {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
+    data := s.data.Load()
     
-    records := make([]models.NormalizedCRMRecord, len(s.crmRecords))
-    copy(records, s.crmRecords)
+    records := make([]models.NormalizedCRMRecord, len(data.crmRecords))
+    copy(records, data.crmRecords)
     return records
 }
 
 func (s *MemoryStore) GetAdsRecordsByDateRange(from, to time.Time) []models.NormalizedAdsRecord {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
+    data := s.data.Load()
     
     var filtered []models.NormalizedAdsRecord
-    for _, record := range s.adsRecords {
-        if (record.Date.Equal(from) || record.Date.After(from)) && 
+    for _, record := range data.adsRecords {
+        if (record.Date.Equal(from) || record.Date.After(from)) &&
            (record.Date.Equal(to) || record.Date.Before(to)) {
             filtered = append(filtered, record)
         }
@@ -69,13 +250,12 @@ func (s *MemoryStore) GetAdsRecordsByDateRange(from, to time.Time) []models.Norm
 }
 
 func (s *MemoryStore) GetCRMRecordsByDateRange(from, to time.Time) []models.NormalizedCRMRecord {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
+    data := s.data.Load()
     
     var filtered []models.NormalizedCRMRecord
-    for _, record := range s.crmRecords {
+    for _, record := range data.crmRecords {
         recordDate := time.Date(record.CreatedAt.Year(), record.CreatedAt.Month(), record.CreatedAt.Day(), 0, 0, 0, 0, time.UTC)
-        if (recordDate.Equal(from) || recordDate.After(from)) && 
+        if (recordDate.Equal(from) || recordDate.After(from)) &&
            (recordDate.Equal(to) || recordDate.Before(to)) {
             filtered = append(filtered, record)
         }
@@ -83,14 +263,89 @@ func (s *MemoryStore) GetCRMRecordsByDateRange(from, to time.Time) []models.Norm
     return filtered
 }
 
+// GetAggregates returns the metrics precomputed from the stored records, or
+// nil when no aggregator is set or nothing has been stored yet.
+func (s *MemoryStore) GetAggregates() *models.Aggregates {
+    return s.data.Load().aggregates
+}
+
+// GetQualityReports returns the latest and the previous ingest's quality
+// reports; either is nil when not enough ingests have run.
+func (s *MemoryStore) GetQualityReports() (current, previous *models.DataQualityReport) {
+    data := s.data.Load()
+    return data.currentReport, data.previousReport
+}
+
+// Checksum returns a hash of the stored ads and CRM records that doesn't
+// depend on their order, so two ingests of the same data compare equal.
+func (s *MemoryStore) Checksum() string {
+    return s.data.Load().checksum
+}
+
+// adsRecordKeys identifies each record by its JSON encoding. Record IDs
+// are left out since the index scheme numbers records by position.
+func adsRecordKeys(records []models.NormalizedAdsRecord) []string {
+    keys := make([]string, 0, len(records))
+    for _, record := range records {
+        record.Quality.RecordID = ""
+        data, _ := json.Marshal(record)
+        keys = append(keys, string(data))
+    }
+    return keys
+}
+
+// crmRecordKeys is adsRecordKeys for CRM records
+func crmRecordKeys(records []models.NormalizedCRMRecord) []string {
+    keys := make([]string, 0, len(records))
+    for _, record := range records {
+        record.Quality.RecordID = ""
+        data, _ := json.Marshal(record)
+        keys = append(keys, string(data))
+    }
+    return keys
+}
+
+// checksum hashes the record keys, sorted so upstream ordering doesn't
+// change the result.
+func checksum(adsKeys, crmKeys []string) string {
+    encoded := make([]string, 0, len(adsKeys)+len(crmKeys))
+    for _, key := range adsKeys {
+        encoded = append(encoded, "ads:"+key)
+    }
+    for _, key := range crmKeys {
+        encoded = append(encoded, "crm:"+key)
+    }
+    sort.Strings(encoded)
+    
+    h := sha256.New()
+    for _, record := range encoded {
+        h.Write([]byte(record))
+        h.Write([]byte{'\n'})
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
 func (s *MemoryStore) GetLastIngestTime() time.Time {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
-    return s.lastIngest
+    return s.data.Load().lastIngest
+}
+
+// HasIngested reports whether any ingest has stored data since startup,
+// even an empty one
+func (s *MemoryStore) HasIngested() bool {
+    return !s.data.Load().lastIngest.IsZero()
 }
 
-func (s *MemoryStore) HasData() bool {
-    s.mu.RLock()
-    defer s.mu.RUnlock()
-    return len(s.adsRecords) > 0 && len(s.crmRecords) > 0
+// HasData reports whether the store satisfies a readiness requirement:
+// "ingest" once any ingest has completed, even with empty datasets, "any"
+// when ads or CRM records are present, and "both" when both are.
+func (s *MemoryStore) HasData(requirement string) bool {
+    data := s.data.Load()
+    switch requirement {
+    case "both":
+        return len(data.adsRecords) > 0 && len(data.crmRecords) > 0
+    case "any":
+        return len(data.adsRecords) > 0 || len(data.crmRecords) > 0
+    default:
+        return s.HasIngested()
+    }
 }
@@ -2,24 +2,107 @@ package transformer
 
 import (
     "fmt"
+    "math/rand"
     "regexp"
+    "sort"
     "strings"
+    "sync"
     "time"
     
+    "admira-etl/internal/config"
     "admira-etl/internal/models"
 )
 
+// countryCallingCodes maps supported default regions to E.164 calling codes
+var countryCallingCodes = map[string]string{
+    "US": "1", "CA": "1", "MX": "52", "GB": "44", "ES": "34", "FR": "33",
+    "DE": "49", "IT": "39", "PT": "351", "AR": "54", "BR": "55", "CL": "56",
+    "CO": "57", "PE": "51",
+}
+
 type Transformer struct {
-    emailRegex *regexp.Regexp
+    emailRegex           *regexp.Regexp
+    phoneRegex           *regexp.Regexp
+    currencyRegex        *regexp.Regexp
+    dedupUTMFields       []string
+    phoneRegion          string
+    defaultSources       map[string]string
+    canonicalizeChannels bool
+    recordIDScheme       string // index or business
+    currencyPath         string // currency, amount.currency or empty to skip
+    nearDuplicateWindow  time.Duration
+    redactPII            bool
+    maxCommonIssues      int
+    validCampaigns       map[string]bool // empty accepts any campaign
+    criticalBelow        float64
+    warningBelow         float64
+    
+    // Acknowledged issue signatures ("field|description"), reported apart
+    // from new issues
+    ackMu        sync.RWMutex
+    acknowledged map[string]bool
 }
 
-func New() *Transformer {
+func New(cfg *config.Config) *Transformer {
+    acknowledged := make(map[string]bool)
+    for _, signature := range cfg.AcknowledgedIssues {
+        acknowledged[signature] = true
+    }
+    
+    validCampaigns := make(map[string]bool)
+    for _, campaign := range cfg.ValidUTMCampaigns {
+        validCampaigns[campaign] = true
+    }
+    
     return &Transformer{
-        emailRegex: regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`),
+        emailRegex:           regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`),
+        phoneRegex:           regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`),
+        currencyRegex:        regexp.MustCompile(`^[A-Z]{3}$`),
+        dedupUTMFields:       cfg.DedupUTMFields,
+        phoneRegion:          strings.ToUpper(cfg.PhoneDefaultRegion),
+        acknowledged:         acknowledged,
+        defaultSources:       cfg.ChannelDefaultSources,
+        canonicalizeChannels: cfg.CanonicalizeChannels,
+        recordIDScheme:       cfg.RecordIDScheme,
+        currencyPath:         cfg.CRMCurrencyPath,
+        nearDuplicateWindow:  cfg.CRMNearDuplicateWindow,
+        redactPII:            cfg.RedactPII,
+        maxCommonIssues:      cfg.MaxCommonIssues,
+        validCampaigns:       validCampaigns,
+        criticalBelow:        cfg.QualityCriticalBelow,
+        warningBelow:         cfg.QualityWarningBelow,
     }
 }
 
-func (t *Transformer) NormalizeAdsRecords(records []models.AdsRecord) []models.NormalizedAdsRecord {
+// AcknowledgeIssues marks issues as known-acceptable so quality reports stop
+// flagging them as new, and returns all acknowledged issues.
+func (t *Transformer) AcknowledgeIssues(issues []models.IssueSignature) []models.IssueSignature {
+    t.ackMu.Lock()
+    defer t.ackMu.Unlock()
+    
+    for _, issue := range issues {
+        t.acknowledged[issue.Field+"|"+issue.Description] = true
+    }
+    
+    acknowledged := []models.IssueSignature{}
+    for signature := range t.acknowledged {
+        parts := strings.SplitN(signature, "|", 2)
+        if len(parts) == 2 {
+            acknowledged = append(acknowledged, models.IssueSignature{Field: parts[0], Description: parts[1]})
+        }
+    }
+    return acknowledged
+}
+
+func (t *Transformer) isAcknowledged(field string, description string) bool {
+    t.ackMu.RLock()
+    defer t.ackMu.RUnlock()
+    return t.acknowledged[field+"|"+description]
+}
+
+// NormalizeAdsRecords validates and normalizes ads records, returning the
+// unique records and the duplicates dropped by deduplication.
+func (t *Transformer) NormalizeAdsRecords(records []models.AdsRecord) ([]models.NormalizedAdsRecord, []models.NormalizedAdsRecord) {
     var normalized []models.NormalizedAdsRecord
     
     for i, record := range records {
@@ -30,35 +113,51 @@ func (t *Transformer) NormalizeAdsRecords(records []models.AdsRecord) []models.N
             ErrorCount:  0,
         }
         
+        channel := t.validateChannel(record.Channel, "channel", &quality)
+        
         normalizedRecord := models.NormalizedAdsRecord{
             Date:        t.validateAndParseDate(record.Date, "date", &quality),
             CampaignID:  t.validateCampaignID(record.CampaignID, "campaign_id", &quality),
-            Channel:     t.validateChannel(record.Channel, "channel", &quality),
+            Channel:     channel,
             Clicks:      t.validateClicks(record.Clicks, "clicks", &quality),
             Impressions: t.validateImpressions(record.Impressions, "impressions", &quality),
             Cost:        t.validateCost(record.Cost, "cost", &quality),
             UTMCampaign: t.validateUTMCampaign(record.UTMCampaign, "utm_campaign", &quality),
-            UTMSource:   t.validateUTMSource(record.UTMSource, "utm_source", &quality),
+            UTMSource:   t.validateUTMSource(record.UTMSource, channel, "utm_source", &quality),
             UTMMedium:   t.validateUTMMedium(record.UTMMedium, "utm_medium", &quality),
             Quality:     quality,
         }
+        t.flagMissingNumbers(record.Missing, &normalizedRecord.Quality)
         
+        // A source inferred from the channel isn't known to CRM records,
+        // which carry no channel, so the join key keeps it unknown
+        keySource := normalizedRecord.UTMSource
+        if record.UTMSource == nil || strings.TrimSpace(*record.UTMSource) == "" {
+            keySource = "unknown"
+        }
         normalizedRecord.UTMKey = t.generateUTMKey(
             normalizedRecord.UTMCampaign,
-            normalizedRecord.UTMSource,
+            keySource,
             normalizedRecord.UTMMedium,
         )
         
         // Final record validation
         normalizedRecord.Quality.IsValid = normalizedRecord.Quality.ErrorCount == 0
         
+        // Business IDs stay stable across ingests, unlike input positions
+        if t.recordIDScheme == "business" {
+            normalizedRecord.Quality.RecordID = "ads_" + t.adsDedupKey(normalizedRecord)
+        }
+        
         normalized = append(normalized, normalizedRecord)
     }
     
     return t.deduplicateAdsRecords(normalized)
 }
 
-func (t *Transformer) NormalizeCRMRecords(records []models.CRMRecord) []models.NormalizedCRMRecord {
+// NormalizeCRMRecords validates and normalizes CRM records, returning the
+// unique records and the duplicates dropped by deduplication.
+func (t *Transformer) NormalizeCRMRecords(records []models.CRMRecord) ([]models.NormalizedCRMRecord, []models.NormalizedCRMRecord) {
     var normalized []models.NormalizedCRMRecord
     
     for i, record := range records {
@@ -72,14 +171,19 @@ func (t *Transformer) NormalizeCRMRecords(records []models.CRMRecord) []models.N
         normalizedRecord := models.NormalizedCRMRecord{
             OpportunityID: t.validateOpportunityID(record.OpportunityID, "opportunity_id", &quality),
             ContactEmail:  t.validateEmail(record.ContactEmail, "contact_email", &quality),
+            Phone:         t.validatePhone(record.Phone, "phone", &quality),
             Stage:         t.validateStage(record.Stage, "stage", &quality),
-            Amount:        t.validateAmount(record.Amount, "amount", &quality),
+            Amount:        t.validateAmount(record.Amount.Value, "amount", &quality),
+            Currency:      t.validateCurrency(record, "currency", &quality),
+            MRR:           t.validateMRR(record.MRR, "mrr", &quality),
             CreatedAt:     t.validateAndParseDateTime(record.CreatedAt, "created_at", &quality),
+            CampaignID:    strings.TrimSpace(record.CampaignID), // Optional, used when MATCH_BY=campaign_id
             UTMCampaign:   t.validateUTMCampaign(record.UTMCampaign, "utm_campaign", &quality),
-            UTMSource:     t.validateUTMSource(record.UTMSource, "utm_source", &quality),
+            UTMSource:     t.validateUTMSource(record.UTMSource, "", "utm_source", &quality),
             UTMMedium:     t.validateUTMMedium(record.UTMMedium, "utm_medium", &quality),
             Quality:       quality,
         }
+        t.flagMissingNumbers(record.Missing, &normalizedRecord.Quality)
         
         normalizedRecord.UTMKey = t.generateUTMKey(
             normalizedRecord.UTMCampaign,
@@ -90,12 +194,85 @@ func (t *Transformer) NormalizeCRMRecords(records []models.CRMRecord) []models.N
         // Final record validation
         normalizedRecord.Quality.IsValid = normalizedRecord.Quality.ErrorCount == 0
         
+        // Records without an opportunity ID keep their index-based ID
+        if t.recordIDScheme == "business" && normalizedRecord.OpportunityID != "unknown" {
+            normalizedRecord.Quality.RecordID = "crm_" + normalizedRecord.OpportunityID
+        }
+        
         normalized = append(normalized, normalizedRecord)
     }
     
     return t.deduplicateCRMRecords(normalized)
 }
 
+// SampleAdsRecords keeps roughly rate (0.0-1.0) of the raw ads records.
+// The same seed always selects the same records for the same input.
+func (t *Transformer) SampleAdsRecords(records []models.AdsRecord, rate float64, seed int64) []models.AdsRecord {
+    if rate >= 1 {
+        return records
+    }
+    
+    rng := rand.New(rand.NewSource(seed))
+    var sampled []models.AdsRecord
+    for _, record := range records {
+        if rng.Float64() < rate {
+            sampled = append(sampled, record)
+        }
+    }
+    return sampled
+}
+
+// SampleCRMRecords keeps roughly rate (0.0-1.0) of the raw CRM records.
+// The same seed always selects the same records for the same input.
+func (t *Transformer) SampleCRMRecords(records []models.CRMRecord, rate float64, seed int64) []models.CRMRecord {
+    if rate >= 1 {
+        return records
+    }
+    
+    rng := rand.New(rand.NewSource(seed))
+    var sampled []models.CRMRecord
+    for _, record := range records {
+        if rng.Float64() < rate {
+            sampled = append(sampled, record)
+        }
+    }
+    return sampled
+}
+
+// flagMissingNumbers marks numeric fields a CSV row left blank as missing,
+// overriding the validator's verdict on the 0 they were read as
+func (t *Transformer) flagMissingNumbers(missing map[string]bool, quality *models.RecordQuality) {
+    for field, isMissing := range missing {
+        if !isMissing {
+            continue
+        }
+        if previous, ok := quality.FieldErrors[field]; !ok || previous.IsValid {
+            quality.ErrorCount++
+        }
+        quality.FieldErrors[field] = models.FieldQuality{
+            IsValid:       false,
+            Description:   "Missing - CSV " + field + " cell is empty",
+            OriginalValue: "",
+        }
+    }
+}
+
+// transformation records a validator changing a value, or returns nil when
+// the value was kept as is
+func transformation(action string, from, to interface{}) *models.Transformation {
+    // Nullable UTM fields are audited by value
+    if p, ok := from.(*string); ok {
+        from = nil
+        if p != nil {
+            from = *p
+        }
+    }
+    if from == to {
+        return nil
+    }
+    return &models.Transformation{Action: action, From: from, To: to}
+}
+
 // ADS Field Validators
 func (t *Transformer) validateAndParseDate(dateStr string, fieldName string, quality *models.RecordQuality) time.Time {
     if strings.TrimSpace(dateStr) == "" {
@@ -137,9 +314,10 @@ func (t *Transformer) validateAndParseDate(dateStr string, fieldName string, qua
 func (t *Transformer) validateCampaignID(id string, fieldName string, quality *models.RecordQuality) string {
     if strings.TrimSpace(id) == "" {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Missing - Campaign ID is empty, using 'unknown'",
-            OriginalValue: id,
+            IsValid:        false,
+            Description:    "Missing - Campaign ID is empty, using 'unknown'",
+            OriginalValue:  id,
+            Transformation: transformation("default", id, "unknown"),
         }
         quality.ErrorCount++
         return "unknown"
@@ -156,23 +334,35 @@ func (t *Transformer) validateCampaignID(id string, fieldName string, quality *m
 func (t *Transformer) validateChannel(channel string, fieldName string, quality *models.RecordQuality) string {
     if strings.TrimSpace(channel) == "" {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Missing - Channel is empty",
-            OriginalValue: channel,
+            IsValid:        false,
+            Description:    "Missing - Channel is empty",
+            OriginalValue:  channel,
+            Transformation: transformation("default", channel, "unknown"),
         }
         quality.ErrorCount++
         return "unknown"
     }
     
+    // Map variants such as "Google Ads" or "google-ads" to "google_ads"
+    canonical := channel
+    if t.canonicalizeChannels {
+        canonical = strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(channel)))
+    }
+    
     validChannels := []string{"google_ads", "facebook_ads", "tiktok_ads", "linkedin_ads", "twitter_ads"}
     for _, validChannel := range validChannels {
-        if channel == validChannel {
+        if canonical == validChannel {
+            description := "Valid channel"
+            if canonical != channel {
+                description = fmt.Sprintf("Valid channel (canonicalized to %s)", canonical)
+            }
             quality.FieldErrors[fieldName] = models.FieldQuality{
-                IsValid:       true,
-                Description:   "Valid channel",
-                OriginalValue: channel,
+                IsValid:        true,
+                Description:    description,
+                OriginalValue:  channel,
+                Transformation: transformation("canonicalize", channel, canonical),
             }
-            return channel
+            return canonical
         }
     }
     
@@ -188,9 +378,10 @@ func (t *Transformer) validateChannel(channel string, fieldName string, quality
 func (t *Transformer) validateClicks(clicks int, fieldName string, quality *models.RecordQuality) int {
     if clicks < 0 {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Invalid - Clicks cannot be negative, setting to 0",
-            OriginalValue: clicks,
+            IsValid:        false,
+            Description:    "Invalid - Clicks cannot be negative, setting to 0",
+            OriginalValue:  clicks,
+            Transformation: transformation("clamp", clicks, 0),
         }
         quality.ErrorCount++
         return 0
@@ -207,9 +398,10 @@ func (t *Transformer) validateClicks(clicks int, fieldName string, quality *mode
 func (t *Transformer) validateImpressions(impressions int, fieldName string, quality *models.RecordQuality) int {
     if impressions < 0 {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Invalid - Impressions cannot be negative, setting to 0",
-            OriginalValue: impressions,
+            IsValid:        false,
+            Description:    "Invalid - Impressions cannot be negative, setting to 0",
+            OriginalValue:  impressions,
+            Transformation: transformation("clamp", impressions, 0),
         }
         quality.ErrorCount++
         return 0
@@ -226,9 +418,10 @@ func (t *Transformer) validateImpressions(impressions int, fieldName string, qua
 func (t *Transformer) validateCost(cost float64, fieldName string, quality *models.RecordQuality) float64 {
     if cost < 0 {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Invalid - Cost cannot be negative, setting to 0",
-            OriginalValue: cost,
+            IsValid:        false,
+            Description:    "Invalid - Cost cannot be negative, setting to 0",
+            OriginalValue:  cost,
+            Transformation: transformation("clamp", cost, 0),
         }
         quality.ErrorCount++
         return 0
@@ -246,9 +439,10 @@ func (t *Transformer) validateCost(cost float64, fieldName string, quality *mode
 func (t *Transformer) validateOpportunityID(id string, fieldName string, quality *models.RecordQuality) string {
     if strings.TrimSpace(id) == "" {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Missing - Opportunity ID is empty",
-            OriginalValue: id,
+            IsValid:        false,
+            Description:    "Missing - Opportunity ID is empty",
+            OriginalValue:  id,
+            Transformation: transformation("default", id, "unknown"),
         }
         quality.ErrorCount++
         return "unknown"
@@ -267,7 +461,7 @@ func (t *Transformer) validateEmail(email string, fieldName string, quality *mod
         quality.FieldErrors[fieldName] = models.FieldQuality{
             IsValid:       false,
             Description:   "Missing - Email is empty",
-            OriginalValue: email,
+            OriginalValue: t.emailForReport(email),
         }
         quality.ErrorCount++
         return email
@@ -277,7 +471,7 @@ func (t *Transformer) validateEmail(email string, fieldName string, quality *mod
         quality.FieldErrors[fieldName] = models.FieldQuality{
             IsValid:       false,
             Description:   "Invalid email format",
-            OriginalValue: email,
+            OriginalValue: t.emailForReport(email),
         }
         quality.ErrorCount++
         return email
@@ -286,17 +480,87 @@ func (t *Transformer) validateEmail(email string, fieldName string, quality *mod
     quality.FieldErrors[fieldName] = models.FieldQuality{
         IsValid:       true,
         Description:   "Valid email",
-        OriginalValue: email,
+        OriginalValue: t.emailForReport(email),
     }
     return email
 }
 
+// emailForReport masks the email for quality reports when REDACT_PII is set
+func (t *Transformer) emailForReport(email string) string {
+    if t.redactPII {
+        return MaskEmail(email)
+    }
+    return email
+}
+
+// MaskEmail keeps the first character of the local part and the domain,
+// e.g. j***@domain.com, enough to debug without exposing the address.
+func MaskEmail(email string) string {
+    at := strings.LastIndex(email, "@")
+    if at < 1 {
+        if email == "" {
+            return ""
+        }
+        return "***"
+    }
+    return email[:1] + "***" + email[at:]
+}
+
+// validatePhone normalizes a phone number to E.164. Numbers without an
+// international prefix are assumed to belong to the configured default region.
+// Phone is optional, so an empty value is valid.
+func (t *Transformer) validatePhone(phone string, fieldName string, quality *models.RecordQuality) string {
+    if strings.TrimSpace(phone) == "" {
+        quality.FieldErrors[fieldName] = models.FieldQuality{
+            IsValid:       true,
+            Description:   "No phone provided",
+            OriginalValue: phone,
+        }
+        return ""
+    }
+    
+    normalized := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(strings.TrimSpace(phone))
+    switch {
+    case strings.HasPrefix(normalized, "+"):
+    case strings.HasPrefix(normalized, "00"):
+        normalized = "+" + normalized[2:]
+    default:
+        if code, ok := countryCallingCodes[t.phoneRegion]; ok {
+            if code == "1" {
+                normalized = strings.TrimPrefix(normalized, "1")
+            } else {
+                normalized = strings.TrimPrefix(normalized, "0")
+            }
+            normalized = "+" + code + normalized
+        }
+    }
+    
+    if !t.phoneRegex.MatchString(normalized) {
+        quality.FieldErrors[fieldName] = models.FieldQuality{
+            IsValid:       false,
+            Description:   "Invalid phone - Cannot normalize to E.164",
+            OriginalValue: phone,
+        }
+        quality.ErrorCount++
+        return phone
+    }
+    
+    quality.FieldErrors[fieldName] = models.FieldQuality{
+        IsValid:        true,
+        Description:    "Valid phone",
+        OriginalValue:  phone,
+        Transformation: transformation("normalize", phone, normalized),
+    }
+    return normalized
+}
+
 func (t *Transformer) validateStage(stage string, fieldName string, quality *models.RecordQuality) string {
     if strings.TrimSpace(stage) == "" {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Missing - Stage is empty",
-            OriginalValue: stage,
+            IsValid:        false,
+            Description:    "Missing - Stage is empty",
+            OriginalValue:  stage,
+            Transformation: transformation("default", stage, "unknown"),
         }
         quality.ErrorCount++
         return "unknown"
@@ -326,9 +590,10 @@ func (t *Transformer) validateStage(stage string, fieldName string, quality *mod
 func (t *Transformer) validateAmount(amount float64, fieldName string, quality *models.RecordQuality) float64 {
     if amount < 0 {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Invalid - Amount cannot be negative, setting to 0",
-            OriginalValue: amount,
+            IsValid:        false,
+            Description:    "Invalid - Amount cannot be negative, setting to 0",
+            OriginalValue:  amount,
+            Transformation: transformation("clamp", amount, 0),
         }
         quality.ErrorCount++
         return 0
@@ -342,6 +607,74 @@ func (t *Transformer) validateAmount(amount float64, fieldName string, quality *
     return amount
 }
 
+// validateCurrency reads the amount currency from the configured location:
+// the flat "currency" field or the nested "amount.currency".
+func (t *Transformer) validateCurrency(record models.CRMRecord, fieldName string, quality *models.RecordQuality) string {
+    if t.currencyPath == "" {
+        return ""
+    }
+    
+    var currency string
+    switch t.currencyPath {
+    case "currency":
+        currency = record.Currency
+    case "amount.currency":
+        currency = record.Amount.Currency
+    }
+    
+    raw := currency
+    currency = strings.ToUpper(strings.TrimSpace(currency))
+    if currency == "" {
+        quality.FieldErrors[fieldName] = models.FieldQuality{
+            IsValid:        false,
+            Description:    "Missing - Currency could not be determined from " + t.currencyPath,
+            OriginalValue:  currency,
+            Transformation: transformation("default", raw, "unknown"),
+        }
+        quality.ErrorCount++
+        return "unknown"
+    }
+    
+    if !t.currencyRegex.MatchString(currency) {
+        quality.FieldErrors[fieldName] = models.FieldQuality{
+            IsValid:        false,
+            Description:    "Invalid - Currency is not a 3-letter ISO 4217 code",
+            OriginalValue:  currency,
+            Transformation: transformation("default", raw, "unknown"),
+        }
+        quality.ErrorCount++
+        return "unknown"
+    }
+    
+    quality.FieldErrors[fieldName] = models.FieldQuality{
+        IsValid:        true,
+        Description:    "Valid currency",
+        OriginalValue:  currency,
+        Transformation: transformation("normalize", raw, currency),
+    }
+    return currency
+}
+
+func (t *Transformer) validateMRR(mrr float64, fieldName string, quality *models.RecordQuality) float64 {
+    if mrr < 0 {
+        quality.FieldErrors[fieldName] = models.FieldQuality{
+            IsValid:        false,
+            Description:    "Invalid - MRR cannot be negative, setting to 0",
+            OriginalValue:  mrr,
+            Transformation: transformation("clamp", mrr, 0),
+        }
+        quality.ErrorCount++
+        return 0
+    }
+    
+    quality.FieldErrors[fieldName] = models.FieldQuality{
+        IsValid:       true,
+        Description:   "Valid MRR",
+        OriginalValue: mrr,
+    }
+    return mrr
+}
+
 func (t *Transformer) validateAndParseDateTime(dateTimeStr string, fieldName string, quality *models.RecordQuality) time.Time {
     if strings.TrimSpace(dateTimeStr) == "" {
         quality.FieldErrors[fieldName] = models.FieldQuality{
@@ -372,9 +705,21 @@ func (t *Transformer) validateAndParseDateTime(dateTimeStr string, fieldName str
         }
     }
     
+    // Fall back to date-only values, treated as midnight
+    for _, format := range []string{"2006-01-02", "2006/01/02"} {
+        if date, err := time.Parse(format, dateTimeStr); err == nil {
+            quality.FieldErrors[fieldName] = models.FieldQuality{
+                IsValid:       true,
+                Description:   "Valid date without time, assuming midnight",
+                OriginalValue: dateTimeStr,
+            }
+            return date
+        }
+    }
+    
     quality.FieldErrors[fieldName] = models.FieldQuality{
         IsValid:       false,
-        Description:   "Invalid datetime format - Expected ISO format or YYYY-MM-DD HH:MM:SS",
+        Description:   "Invalid datetime format - Expected ISO format, YYYY-MM-DD HH:MM:SS or YYYY-MM-DD",
         OriginalValue: dateTimeStr,
     }
     quality.ErrorCount++
@@ -384,13 +729,25 @@ func (t *Transformer) validateAndParseDateTime(dateTimeStr string, fieldName str
 // UTM Validators
 func (t *Transformer) validateUTMCampaign(campaign string, fieldName string, quality *models.RecordQuality) string {
     if strings.TrimSpace(campaign) == "" {
+        quality.FieldErrors[fieldName] = models.FieldQuality{
+            IsValid:        false,
+            Description:    "Missing - UTM Campaign is empty, using 'unknown'",
+            OriginalValue:  campaign,
+            Transformation: transformation("default", campaign, "unknown"),
+        }
+        quality.ErrorCount++
+        return "unknown"
+    }
+    
+    // Unknown campaigns are flagged but kept, they are usually tagging typos
+    if len(t.validCampaigns) > 0 && !t.validCampaigns[strings.TrimSpace(campaign)] {
         quality.FieldErrors[fieldName] = models.FieldQuality{
             IsValid:       false,
-            Description:   "Missing - UTM Campaign is empty, using 'unknown'",
+            Description:   "Invalid - UTM Campaign is not in the known campaign list",
             OriginalValue: campaign,
         }
         quality.ErrorCount++
-        return "unknown"
+        return campaign
     }
     
     quality.FieldErrors[fieldName] = models.FieldQuality{
@@ -401,21 +758,36 @@ func (t *Transformer) validateUTMCampaign(campaign string, fieldName string, qua
     return campaign
 }
 
-func (t *Transformer) validateUTMSource(source *string, fieldName string, quality *models.RecordQuality) string {
+// validateUTMSource falls back to the channel's configured default source
+// when the UTM source is missing, and to "unknown" otherwise.
+func (t *Transformer) validateUTMSource(source *string, channel string, fieldName string, quality *models.RecordQuality) string {
     if source == nil || strings.TrimSpace(*source) == "" {
+        if inferred, ok := t.defaultSources[channel]; ok {
+            quality.FieldErrors[fieldName] = models.FieldQuality{
+                IsValid:        false,
+                Description:    fmt.Sprintf("Missing - UTM Source is null or empty, inferred '%s' from channel", inferred),
+                OriginalValue:  source,
+                Transformation: transformation("infer", source, inferred),
+            }
+            quality.ErrorCount++
+            return inferred
+        }
+        
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Missing - UTM Source is null or empty, using 'unknown'",
-            OriginalValue: source,
+            IsValid:        false,
+            Description:    "Missing - UTM Source is null or empty, using 'unknown'",
+            OriginalValue:  source,
+            Transformation: transformation("default", source, "unknown"),
         }
         quality.ErrorCount++
         return "unknown"
     }
     
     quality.FieldErrors[fieldName] = models.FieldQuality{
-        IsValid:       true,
-        Description:   "Valid UTM source",
-        OriginalValue: *source,
+        IsValid:        true,
+        Description:    "Valid UTM source",
+        OriginalValue:  *source,
+        Transformation: transformation("trim", *source, strings.TrimSpace(*source)),
     }
     return strings.TrimSpace(*source)
 }
@@ -423,18 +795,20 @@ func (t *Transformer) validateUTMSource(source *string, fieldName string, qualit
 func (t *Transformer) validateUTMMedium(medium *string, fieldName string, quality *models.RecordQuality) string {
     if medium == nil || strings.TrimSpace(*medium) == "" {
         quality.FieldErrors[fieldName] = models.FieldQuality{
-            IsValid:       false,
-            Description:   "Missing - UTM Medium is null or empty, using 'unknown'",
-            OriginalValue: medium,
+            IsValid:        false,
+            Description:    "Missing - UTM Medium is null or empty, using 'unknown'",
+            OriginalValue:  medium,
+            Transformation: transformation("default", medium, "unknown"),
         }
         quality.ErrorCount++
         return "unknown"
     }
     
     quality.FieldErrors[fieldName] = models.FieldQuality{
-        IsValid:       true,
-        Description:   "Valid UTM medium",
-        OriginalValue: *medium,
+        IsValid:        true,
+        Description:    "Valid UTM medium",
+        OriginalValue:  *medium,
+        Transformation: transformation("trim", *medium, strings.TrimSpace(*medium)),
     }
     return strings.TrimSpace(*medium)
 }
@@ -446,15 +820,13 @@ func (t *Transformer) generateUTMKey(campaign, source, medium string) string {
     return fmt.Sprintf("%s|%s|%s", campaign, source, medium)
 }
 
-func (t *Transformer) deduplicateAdsRecords(records []models.NormalizedAdsRecord) []models.NormalizedAdsRecord {
+func (t *Transformer) deduplicateAdsRecords(records []models.NormalizedAdsRecord) ([]models.NormalizedAdsRecord, []models.NormalizedAdsRecord) {
     seen := make(map[string]int) // map to track index of first occurrence
     var unique []models.NormalizedAdsRecord
+    var duplicates []models.NormalizedAdsRecord
     
     for i, record := range records {
-        key := fmt.Sprintf("%s|%s|%s", 
-            record.Date.Format("2006-01-02"), 
-            record.CampaignID, 
-            record.Channel)
+        key := t.adsDedupKey(record)
         
         if existingIndex, exists := seen[key]; !exists {
             seen[key] = i
@@ -468,15 +840,40 @@ func (t *Transformer) deduplicateAdsRecords(records []models.NormalizedAdsRecord
             }
             record.Quality.ErrorCount++
             record.Quality.IsValid = false
+            duplicates = append(duplicates, record)
         }
     }
     
-    return unique
+    return unique, duplicates
+}
+
+// adsDedupKey builds the identity of an ads record: date|campaign_id|channel,
+// optionally extended with the configured UTM fields. Adding UTM fields keeps
+// rows that differ only by attribution, at the cost of letting re-sent rows
+// with inconsistent UTM tagging through as distinct records.
+func (t *Transformer) adsDedupKey(record models.NormalizedAdsRecord) string {
+    key := fmt.Sprintf("%s|%s|%s", 
+        record.Date.Format("2006-01-02"), 
+        record.CampaignID, 
+        record.Channel)
+    
+    for _, field := range t.dedupUTMFields {
+        switch field {
+        case "campaign":
+            key += "|" + record.UTMCampaign
+        case "source":
+            key += "|" + record.UTMSource
+        case "medium":
+            key += "|" + record.UTMMedium
+        }
+    }
+    return key
 }
 
-func (t *Transformer) deduplicateCRMRecords(records []models.NormalizedCRMRecord) []models.NormalizedCRMRecord {
+func (t *Transformer) deduplicateCRMRecords(records []models.NormalizedCRMRecord) ([]models.NormalizedCRMRecord, []models.NormalizedCRMRecord) {
     seen := make(map[string]int)
     var unique []models.NormalizedCRMRecord
+    var duplicates []models.NormalizedCRMRecord
     
     for i, record := range records {
         if existingIndex, exists := seen[record.OpportunityID]; !exists {
@@ -491,10 +888,73 @@ func (t *Transformer) deduplicateCRMRecords(records []models.NormalizedCRMRecord
             }
             record.Quality.ErrorCount++
             record.Quality.IsValid = false
+            duplicates = append(duplicates, record)
         }
     }
     
-    return unique
+    if t.nearDuplicateWindow > 0 {
+        var nearDuplicates []models.NormalizedCRMRecord
+        unique, nearDuplicates = t.deduplicateNearCRMRecords(unique)
+        duplicates = append(duplicates, nearDuplicates...)
+    }
+    
+    return unique, duplicates
+}
+
+// deduplicateNearCRMRecords drops likely re-sends that the opportunity ID pass
+// misses: the CRM re-issues the ID after an edit, but the record keeps its
+// contact email and is created within nearDuplicateWindow of the first one.
+// The latest record is kept since it carries the edit; on equal created_at
+// the later one in the input wins.
+func (t *Transformer) deduplicateNearCRMRecords(records []models.NormalizedCRMRecord) ([]models.NormalizedCRMRecord, []models.NormalizedCRMRecord) {
+    kept := make(map[string][]int) // indexes into unique, per email
+    var unique []models.NormalizedCRMRecord
+    var nearDuplicates []models.NormalizedCRMRecord
+    
+    for _, record := range records {
+        email := strings.ToLower(strings.TrimSpace(record.ContactEmail))
+        if email == "" || record.CreatedAt.IsZero() {
+            unique = append(unique, record)
+            continue
+        }
+        
+        match := -1
+        for _, i := range kept[email] {
+            gap := record.CreatedAt.Sub(unique[i].CreatedAt)
+            if gap < 0 {
+                gap = -gap
+            }
+            if gap <= t.nearDuplicateWindow {
+                match = i
+                break
+            }
+        }
+        
+        switch {
+        case match < 0:
+            kept[email] = append(kept[email], len(unique))
+            unique = append(unique, record)
+        case record.CreatedAt.Before(unique[match].CreatedAt):
+            nearDuplicates = append(nearDuplicates, t.flagNearDuplicate(record, unique[match]))
+        default:
+            nearDuplicates = append(nearDuplicates, t.flagNearDuplicate(unique[match], record))
+            unique[match] = record
+        }
+    }
+    
+    return unique, nearDuplicates
+}
+
+// flagNearDuplicate marks stale as superseded by the re-sent record latest
+func (t *Transformer) flagNearDuplicate(stale, latest models.NormalizedCRMRecord) models.NormalizedCRMRecord {
+    stale.Quality.FieldErrors["near_duplicate"] = models.FieldQuality{
+        IsValid:       false,
+        Description:   fmt.Sprintf("Likely re-send of opportunity %s (same email, created within %s)", latest.OpportunityID, t.nearDuplicateWindow),
+        OriginalValue: stale.OpportunityID,
+    }
+    stale.Quality.ErrorCount++
+    stale.Quality.IsValid = false
+    return stale
 }
 
 // Generate Quality Report
@@ -518,67 +978,230 @@ func (t *Transformer) GenerateQualityReport(adsRecords []models.NormalizedAdsRec
         }
     }
     
-    adsScore := 0.0
-    if len(adsRecords) > 0 {
-        adsScore = float64(validAds) / float64(len(adsRecords)) * 100
-    }
-    
-    crmScore := 0.0
-    if len(crmRecords) > 0 {
-        crmScore = float64(validCRM) / float64(len(crmRecords)) * 100
-    }
-    
-    overallScore := 0.0
-    totalRecords := len(adsRecords) + len(crmRecords)
-    if totalRecords > 0 {
-        overallScore = float64(validAds+validCRM) / float64(totalRecords) * 100
+    status := "ok"
+    switch {
+    case len(adsRecords) == 0 && len(crmRecords) == 0:
+        status = "no_data"
+    case len(adsRecords) == 0 || len(crmRecords) == 0:
+        status = "partial"
     }
     
     // Identify common issues
-    commonIssues := t.identifyCommonIssues(adsRecords, crmRecords)
+    commonIssues, acknowledgedIssues, issueCounts := t.identifyCommonIssues(adsRecords, crmRecords)
+    overallScore := qualityScore(validAds+validCRM, len(adsRecords)+len(crmRecords))
     
     return models.DataQualityReport{
         Summary: models.QualitySummary{
+            Status:              status,
+            Severity:            t.severity(overallScore),
             TotalAdsRecords:     len(adsRecords),
             ValidAdsRecords:     validAds,
-            AdsQualityScore:     adsScore,
+            AdsQualityScore:     qualityScore(validAds, len(adsRecords)),
             TotalCRMRecords:     len(crmRecords),
             ValidCRMRecords:     validCRM,
-            CRMQualityScore:     crmScore,
+            CRMQualityScore:     qualityScore(validCRM, len(crmRecords)),
             OverallQualityScore: overallScore,
             CommonIssues:        commonIssues,
+            AcknowledgedIssues:  acknowledgedIssues,
         },
         AdsReport: adsQuality,
         CRMReport: crmQuality,
         Timestamp: time.Now().Format(time.RFC3339),
+        
+        IssueCounts: issueCounts,
+    }
+}
+
+// CheckCompleteness reports which days between from and to (inclusive) have
+// no ads or no CRM records, since a missing day silently reduces totals.
+func (t *Transformer) CheckCompleteness(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord, from, to time.Time) models.CompletenessReport {
+    adsDates := make(map[string]bool)
+    for _, record := range adsRecords {
+        adsDates[record.Date.Format("2006-01-02")] = true
+    }
+    
+    crmDates := make(map[string]bool)
+    for _, record := range crmRecords {
+        crmDates[record.CreatedAt.Format("2006-01-02")] = true
+    }
+    
+    report := models.CompletenessReport{
+        From:            from.Format("2006-01-02"),
+        To:              to.Format("2006-01-02"),
+        MissingAdsDates: []string{},
+        MissingCRMDates: []string{},
     }
+    
+    for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+        date := day.Format("2006-01-02")
+        report.ExpectedDays++
+        if !adsDates[date] {
+            report.MissingAdsDates = append(report.MissingAdsDates, date)
+        }
+        if !crmDates[date] {
+            report.MissingCRMDates = append(report.MissingCRMDates, date)
+        }
+    }
+    
+    return report
 }
 
-func (t *Transformer) identifyCommonIssues(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord) []string {
+// identifyCommonIssues returns the issues appearing more than once, split into
+// new issues and those acknowledged as known-acceptable, along with the
+// uncapped counts of the new ones.
+func (t *Transformer) identifyCommonIssues(adsRecords []models.NormalizedAdsRecord, crmRecords []models.NormalizedCRMRecord) ([]string, []string, map[string]int) {
     issueCount := make(map[string]int)
+    ackCount := make(map[string]int)
     
-    for _, record := range adsRecords {
-        for _, fieldError := range record.Quality.FieldErrors {
-            if !fieldError.IsValid {
+    countIssues := func(fieldErrors map[string]models.FieldQuality) {
+        for field, fieldError := range fieldErrors {
+            if fieldError.IsValid {
+                continue
+            }
+            if t.isAcknowledged(field, fieldError.Description) {
+                ackCount[fieldError.Description]++
+            } else {
                 issueCount[fieldError.Description]++
             }
         }
     }
     
+    for _, record := range adsRecords {
+        countIssues(record.Quality.FieldErrors)
+    }
+    
     for _, record := range crmRecords {
-        for _, fieldError := range record.Quality.FieldErrors {
-            if !fieldError.IsValid {
-                issueCount[fieldError.Description]++
-            }
-        }
+        countIssues(record.Quality.FieldErrors)
     }
     
-    var commonIssues []string
-    for issue, count := range issueCount {
+    return rankIssues(issueCount, t.maxCommonIssues), rankIssues(ackCount, 0), issueCount
+}
+
+// rankIssues lists the issues that appear more than once, most frequent
+// first, then alphabetically. With a positive limit, issues past it
+// collapse into a "+M more" entry.
+func rankIssues(counts map[string]int, limit int) []string {
+    var issues []string
+    for issue, count := range counts {
         if count > 1 { // Only include issues that appear more than once
-            commonIssues = append(commonIssues, fmt.Sprintf("%s (occurs %d times)", issue, count))
+            issues = append(issues, issue)
         }
     }
+    // Ties sort alphabetically so reports are stable across ingests
+    sort.Slice(issues, func(i, j int) bool {
+        if counts[issues[i]] != counts[issues[j]] {
+            return counts[issues[i]] > counts[issues[j]]
+        }
+        return issues[i] < issues[j]
+    })
+    
+    omitted := 0
+    if limit > 0 && len(issues) > limit {
+        omitted = len(issues) - limit
+        issues = issues[:limit]
+    }
     
-    return commonIssues
+    ranked := make([]string, 0, len(issues)+1)
+    for _, issue := range issues {
+        ranked = append(ranked, fmt.Sprintf("%s (occurs %d times)", issue, counts[issue]))
+    }
+    if omitted > 0 {
+        ranked = append(ranked, fmt.Sprintf("+%d more", omitted))
+    }
+    return ranked
+}
+
+// DiffQualityReports compares two quality reports, reporting score deltas
+// (current minus previous) and common issues that weren't common in the
+// previous report. Issues are compared on the uncapped counts, so one that
+// was only hidden in the previous "+M more" entry isn't reported as new.
+func (t *Transformer) DiffQualityReports(current, previous models.DataQualityReport) models.QualityDiff {
+    newCounts := make(map[string]int)
+    for issue, count := range current.IssueCounts {
+        if previous.IssueCounts[issue] <= 1 {
+            newCounts[issue] = count
+        }
+    }
+    newIssues := rankIssues(newCounts, 0)
+    
+    return models.QualityDiff{
+        OverallScoreDelta: scoreDelta(current.Summary.OverallQualityScore, previous.Summary.OverallQualityScore),
+        AdsScoreDelta:     scoreDelta(current.Summary.AdsQualityScore, previous.Summary.AdsQualityScore),
+        CRMScoreDelta:     scoreDelta(current.Summary.CRMQualityScore, previous.Summary.CRMQualityScore),
+        NewIssues:         newIssues,
+        CurrentTimestamp:  current.Timestamp,
+        PreviousTimestamp: previous.Timestamp,
+    }
+}
+
+// DuplicateCollisions summarizes the dedup keys behind dropped duplicates,
+// most shared first. Near-duplicates have no shared key and are left out.
+func (t *Transformer) DuplicateCollisions(adsDuplicates []models.NormalizedAdsRecord, crmDuplicates []models.NormalizedCRMRecord) models.DuplicateReport {
+    adsQuality := make([]models.RecordQuality, 0, len(adsDuplicates))
+    for _, record := range adsDuplicates {
+        adsQuality = append(adsQuality, record.Quality)
+    }
+    crmQuality := make([]models.RecordQuality, 0, len(crmDuplicates))
+    for _, record := range crmDuplicates {
+        crmQuality = append(crmQuality, record.Quality)
+    }
+    
+    return models.DuplicateReport{
+        Ads: collisions(adsQuality),
+        CRM: collisions(crmQuality),
+    }
+}
+
+// collisions counts the records sharing each key recorded by deduplication;
+// every key also has the first occurrence that was kept.
+func collisions(duplicates []models.RecordQuality) []models.DuplicateKey {
+    counts := make(map[string]int)
+    for _, quality := range duplicates {
+        if key, ok := quality.FieldErrors["duplicate"].OriginalValue.(string); ok {
+            counts[key]++
+        }
+    }
+    
+    keys := make([]models.DuplicateKey, 0, len(counts))
+    for key, count := range counts {
+        keys = append(keys, models.DuplicateKey{Key: key, Records: count + 1})
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].Records != keys[j].Records {
+            return keys[i].Records > keys[j].Records
+        }
+        return keys[i].Key < keys[j].Key
+    })
+    return keys
+}
+
+// qualityScore is the percentage of valid records, nil without records
+func qualityScore(valid, total int) *float64 {
+    if total == 0 {
+        return nil
+    }
+    score := float64(valid) / float64(total) * 100
+    return &score
+}
+
+// severity maps the overall quality score onto the configured thresholds
+func (t *Transformer) severity(score *float64) string {
+    switch {
+    case score == nil:
+        return "no_data"
+    case *score < t.criticalBelow:
+        return "critical"
+    case *score < t.warningBelow:
+        return "warning"
+    default:
+        return "ok"
+    }
+}
+
+func scoreDelta(current, previous *float64) *float64 {
+    if current == nil || previous == nil {
+        return nil
+    }
+    delta := *current - *previous
+    return &delta
 }
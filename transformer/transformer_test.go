@@ -0,0 +1,119 @@
+package transformer
+
+import (
+    "testing"
+    "time"
+    
+    "admira-etl/internal/config"
+    "admira-etl/internal/models"
+)
+
+func newQuality() models.RecordQuality {
+    return models.RecordQuality{
+        IsValid:     true,
+        FieldErrors: make(map[string]models.FieldQuality),
+    }
+}
+
+func TestDateOnlyCreatedAtParsesAsMidnight(t *testing.T) {
+    tr := New(&config.Config{})
+    quality := newQuality()
+    
+    got := tr.validateAndParseDateTime("2025-08-01", "created_at", &quality)
+    
+    want := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+    if !got.Equal(want) {
+        t.Errorf("created_at = %v, want %v", got, want)
+    }
+    if quality.ErrorCount != 0 || !quality.FieldErrors["created_at"].IsValid {
+        t.Errorf("date-only created_at flagged invalid: %+v", quality.FieldErrors["created_at"])
+    }
+}
+
+func TestMissingCRMAmountFlagged(t *testing.T) {
+    tr := New(&config.Config{})
+    records := []models.CRMRecord{
+        {OpportunityID: "O-1", ContactEmail: "a@example.com", Stage: "closed_won", CreatedAt: "2025-08-01", Missing: map[string]bool{"amount": true}},
+        {OpportunityID: "O-2", ContactEmail: "b@example.com", Stage: "closed_won", CreatedAt: "2025-08-01"},
+    }
+    
+    normalized, _ := tr.NormalizeCRMRecords(records)
+    if len(normalized) != 2 {
+        t.Fatalf("got %d records, want 2", len(normalized))
+    }
+    
+    if field := normalized[0].Quality.FieldErrors["amount"]; field.IsValid {
+        t.Errorf("missing amount not flagged: %+v", field)
+    }
+    if field := normalized[1].Quality.FieldErrors["amount"]; !field.IsValid {
+        t.Errorf("explicit 0 amount flagged: %+v", field)
+    }
+}
+
+func TestNearDuplicateKeepsLatestEdit(t *testing.T) {
+    tr := New(&config.Config{CRMNearDuplicateWindow: 30 * time.Second})
+    records := []models.CRMRecord{
+        {OpportunityID: "O-2", ContactEmail: "a@example.com", Stage: "closed_won", CreatedAt: "2025-08-01T10:00:20Z", Amount: models.CRMAmount{Value: 150}},
+        {OpportunityID: "O-1", ContactEmail: "a@example.com", Stage: "proposal", CreatedAt: "2025-08-01T10:00:00Z", Amount: models.CRMAmount{Value: 100}},
+    }
+    
+    unique, duplicates := tr.NormalizeCRMRecords(records)
+    if len(unique) != 1 || len(duplicates) != 1 {
+        t.Fatalf("got %d kept, %d duplicates, want 1 and 1", len(unique), len(duplicates))
+    }
+    
+    if unique[0].OpportunityID != "O-2" || unique[0].Amount != 150 {
+        t.Errorf("kept %s with amount %v, want the later O-2 with 150", unique[0].OpportunityID, unique[0].Amount)
+    }
+    if field := duplicates[0].Quality.FieldErrors["near_duplicate"]; duplicates[0].OpportunityID != "O-1" || field.IsValid {
+        t.Errorf("older O-1 not flagged: %s %+v", duplicates[0].OpportunityID, field)
+    }
+}
+
+// adsWithIssues builds ads records, each with one invalid field described
+// by the given issue
+func adsWithIssues(issues ...string) []models.NormalizedAdsRecord {
+    records := make([]models.NormalizedAdsRecord, 0, len(issues))
+    for _, issue := range issues {
+        quality := newQuality()
+        quality.FieldErrors["clicks"] = models.FieldQuality{IsValid: false, Description: issue}
+        records = append(records, models.NormalizedAdsRecord{Quality: quality})
+    }
+    return records
+}
+
+func TestDiffQualityReportsIgnoresCappedIssues(t *testing.T) {
+    tr := New(&config.Config{MaxCommonIssues: 1})
+    
+    // "B" only shows in the previous "+1 more" entry
+    previous := tr.GenerateQualityReport(adsWithIssues("A", "A", "A", "B", "B"), nil)
+    current := tr.GenerateQualityReport(adsWithIssues("B", "B", "B", "C", "C"), nil)
+    
+    diff := tr.DiffQualityReports(current, previous)
+    want := "C (occurs 2 times)"
+    if len(diff.NewIssues) != 1 || diff.NewIssues[0] != want {
+        t.Errorf("new issues = %v, want [%s]", diff.NewIssues, want)
+    }
+}
+
+func TestInferredSourceKeepsCRMJoin(t *testing.T) {
+    tr := New(&config.Config{ChannelDefaultSources: map[string]string{"google_ads": "google"}})
+    medium := "cpc"
+    
+    ads, _ := tr.NormalizeAdsRecords([]models.AdsRecord{
+        {Date: "2025-08-01", CampaignID: "C-1", Channel: "google_ads", Clicks: 10, Cost: 5, UTMCampaign: "summer", UTMMedium: &medium},
+    })
+    crm, _ := tr.NormalizeCRMRecords([]models.CRMRecord{
+        {OpportunityID: "O-1", ContactEmail: "a@example.com", Stage: "lead", CreatedAt: "2025-08-01", UTMCampaign: "summer", UTMMedium: &medium},
+    })
+    if len(ads) != 1 || len(crm) != 1 {
+        t.Fatalf("got %d ads and %d CRM records, want 1 each", len(ads), len(crm))
+    }
+    
+    if ads[0].UTMSource != "google" {
+        t.Errorf("ads utm_source = %q, want inferred google", ads[0].UTMSource)
+    }
+    if ads[0].UTMKey != crm[0].UTMKey {
+        t.Errorf("ads key %q doesn't match CRM key %q", ads[0].UTMKey, crm[0].UTMKey)
+    }
+}